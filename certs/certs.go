@@ -0,0 +1,241 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+const (
+	// CAFile is the name of the CA certificate in the CA folder, to be added to trust stores
+	CAFile = "ca.pem"
+	// CAKeyFile is the name of the CA private key in the CA folder
+	CAKeyFile = "ca-key.pem"
+	// DefaultCADir is the CA folder when none is configured
+	DefaultCADir = "mirage-ca"
+)
+
+// DefaultHosts are the hosts of the generated certificate when none is configured
+var DefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// CA is a local certificate authority issuing the certificates of the mocker
+type CA struct {
+	Cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// LoadOrCreateCA loads the CA from dir, or creates it (and the folder) when it doesn't exist, so the CA
+// added to trust stores keeps working between runs
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := filepath.Join(dir, CAFile)
+	keyPath := filepath.Join(dir, CAKeyFile)
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	switch {
+	case err == nil:
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CA certificate: %w", err)
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("CA key must be an ECDSA key")
+		}
+		return &CA{Cert: cert, key: key}, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("error loading CA: %w", err)
+	}
+
+	ca, certPEM, keyPEM, err := newCA()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating CA folder: %w", err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("error writing CA key: %w", err)
+	}
+	if err := ioutil.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("error writing CA certificate: %w", err)
+	}
+
+	return ca, nil
+}
+
+func newCA() (*CA, []byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error generating CA key: %w", err)
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Mirage Mocker local CA", Organization: []string{"Mirage Mocker"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error creating CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error parsing CA certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error encoding CA key: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return &CA{Cert: cert, key: key}, certPEM, keyPEM, nil
+}
+
+// Issue creates a certificate for the hosts (DNS names or IPs), valid for both server and client
+// authentication
+func (ca *CA) Issue(commonName string, hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error generating key: %w", err)
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Mirage Mocker"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error creating certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error parsing certificate: %w", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der, ca.Cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %w", err)
+	}
+
+	return serial, nil
+}
+
+// CAPath returns the path of the CA certificate used in auto mode
+func CAPath(c config.TLS) string {
+	return filepath.Join(caDir(c), CAFile)
+}
+
+func caDir(c config.TLS) string {
+	if c.CADir == "" {
+		return DefaultCADir
+	}
+
+	return c.CADir
+}
+
+// ServerConfig creates the TLS configuration of the main port. In auto mode, the CA is loaded or created
+// in the CA folder
+func ServerConfig(c config.TLS) (*tls.Config, error) {
+	var cert tls.Certificate
+	if c.Auto {
+		ca, err := LoadOrCreateCA(caDir(c))
+		if err != nil {
+			return nil, err
+		}
+
+		hosts := c.Hosts
+		if len(hosts) == 0 {
+			hosts = DefaultHosts
+		}
+		cert, err = ca.Issue(hosts[0], hosts)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		cert, err = tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+	}
+
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCA != "" {
+		b, err := ioutil.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in client CA %s", c.ClientCA)
+		}
+		tc.ClientCAs = pool
+
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+		if c.ClientAuth == "request" {
+			tc.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return tc, nil
+}
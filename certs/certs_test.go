@@ -0,0 +1,93 @@
+package certs_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rodrigo-kayala/mirage-mocker/certs"
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+func Test_LoadOrCreateCA(t *testing.T) {
+	assert := assert.New(t)
+	dir := filepath.Join(t.TempDir(), "ca")
+
+	ca, err := certs.LoadOrCreateCA(dir)
+	assert.NoError(err)
+	assert.True(ca.Cert.IsCA)
+
+	info, err := os.Stat(filepath.Join(dir, certs.CAKeyFile))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// the CA is reused, so trust stores don't need to be updated
+	loaded, err := certs.LoadOrCreateCA(dir)
+	assert.NoError(err)
+	assert.Equal(ca.Cert.SerialNumber, loaded.Cert.SerialNumber)
+
+	cert, err := ca.Issue("localhost", []string{"localhost", "127.0.0.1"})
+	assert.NoError(err)
+	assert.Equal([]string{"localhost"}, cert.Leaf.DNSNames)
+	assert.Len(cert.Leaf.IPAddresses, 1)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots})
+	assert.NoError(err)
+}
+
+func Test_ServerConfig(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	tc, err := certs.ServerConfig(config.TLS{Auto: true, CADir: dir})
+	assert.NoError(err)
+	assert.Equal(tls.NoClientCert, tc.ClientAuth)
+
+	// the generated CA also verifies the client certificates
+	tc, err = certs.ServerConfig(config.TLS{
+		Auto:     true,
+		CADir:    dir,
+		ClientCA: certs.CAPath(config.TLS{CADir: dir}),
+	})
+	assert.NoError(err)
+	assert.Equal(tls.RequireAndVerifyClientCert, tc.ClientAuth)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = tc
+	server.StartTLS()
+	defer server.Close()
+
+	ca, err := certs.LoadOrCreateCA(dir)
+	assert.NoError(err)
+	clientCert, err := ca.Issue("client-a", nil)
+	assert.NoError(err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	client := func(certificates ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates},
+		}}
+	}
+
+	resp, err := client(clientCert).Get(server.URL)
+	assert.NoError(err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal("client-a", string(body))
+
+	_, err = client().Get(server.URL)
+	assert.Error(err)
+}
@@ -1,52 +1,350 @@
 package config
 
+import "encoding/json"
+
 // Config configuration yaml structure
 type Config struct {
-	Port       int       `yaml:"port"`
-	PrettyLogs bool      `yaml:"pretty-logs"`
-	Services   []Service `yaml:"services"`
+	Port        int       `yaml:"port,omitempty" json:"port,omitempty"`
+	AdminPort   int       `yaml:"admin-port,omitempty" json:"admin-port,omitempty"`
+	PrettyLogs  bool      `yaml:"pretty-logs,omitempty" json:"pretty-logs,omitempty"`
+	JournalSize int       `yaml:"journal-size,omitempty" json:"journal-size,omitempty"`
+	Seed        int64     `yaml:"seed,omitempty" json:"seed,omitempty"`
+	TLS         TLS       `yaml:"tls,omitempty" json:"tls,omitempty"`
+	Services    []Service `yaml:"services,omitempty" json:"services,omitempty"`
+}
+
+// TLS yaml structure. The main port serves HTTPS when a certificate is set, or when auto is set. In auto
+// mode, a local CA is created in ca-dir (or reused, if it exists) and issues a certificate for the hosts
+type TLS struct {
+	Cert       string   `yaml:"cert,omitempty" json:"cert,omitempty"`
+	Key        string   `yaml:"key,omitempty" json:"key,omitempty"`
+	Auto       bool     `yaml:"auto,omitempty" json:"auto,omitempty"`
+	Hosts      []string `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	CADir      string   `yaml:"ca-dir,omitempty" json:"ca-dir,omitempty"`
+	ClientCA   string   `yaml:"client-ca,omitempty" json:"client-ca,omitempty"`
+	ClientAuth string   `yaml:"client-auth,omitempty" json:"client-auth,omitempty"`
+}
+
+// Enabled tells if HTTPS is configured
+func (t TLS) Enabled() bool {
+	return t.Cert != "" || t.Auto
 }
 
 // Service yaml structure
 type Service struct {
-	Parser Parser `yaml:"parser"`
+	Parser Parser `yaml:"parser,omitempty" json:"parser,omitempty"`
 }
 
 // Parser yaml structure
 type Parser struct {
-	Pattern         string            `yaml:"pattern"`
-	Rewrites        []Rewrite         `yaml:"rewrite"`
-	Methods         []string          `yaml:"methods"`
-	Headers         map[string]string `yaml:"headers"`
-	ConfigType      string            `yaml:"type"`
-	TransformLib    string            `yaml:"transform-lib"`
-	TransformSymbol string            `yaml:"transform-symbol"`
-	Response        Response          `yaml:"response"`
-	PassBaseURI     string            `yaml:"pass-base-uri"`
-	Log             bool              `yaml:"log"`
-	Delay           Delay             `yaml:"delay"`
+	Name                    string            `yaml:"name,omitempty" json:"name,omitempty"`
+	Pattern                 string            `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	Rewrites                []Rewrite         `yaml:"rewrite,omitempty" json:"rewrite,omitempty"`
+	Methods                 []string          `yaml:"methods,omitempty" json:"methods,omitempty"`
+	Headers                 map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Query                   map[string]Query  `yaml:"query,omitempty" json:"query,omitempty"`
+	Body                    BodyMatcher       `yaml:"body,omitempty" json:"body,omitempty"`
+	ClientCert              ClientCert        `yaml:"client-cert,omitempty" json:"client-cert,omitempty"`
+	ConfigType              string            `yaml:"type,omitempty" json:"type,omitempty"`
+	TransformLib            string            `yaml:"transform-lib,omitempty" json:"transform-lib,omitempty"`
+	TransformSymbol         string            `yaml:"transform-symbol,omitempty" json:"transform-symbol,omitempty"`
+	TransformExec           Exec              `yaml:"transform-exec,omitempty" json:"transform-exec,omitempty"`
+	TransformResponseSymbol string            `yaml:"transform-response-symbol,omitempty" json:"transform-response-symbol,omitempty"`
+	ModifyRequest           ModifyRequest     `yaml:"modify-request,omitempty" json:"modify-request,omitempty"`
+	ModifyResponse          ModifyResponse    `yaml:"modify-response,omitempty" json:"modify-response,omitempty"`
+	Fallback                Fallback          `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+	Response                Response          `yaml:"response,omitempty" json:"response,omitempty"`
+	PassBaseURI             string            `yaml:"pass-base-uri,omitempty" json:"pass-base-uri,omitempty"`
+	PassBaseURIs            []Upstream        `yaml:"pass-base-uris,omitempty" json:"pass-base-uris,omitempty"`
+	LoadBalancing           LoadBalancing     `yaml:"load-balancing,omitempty" json:"load-balancing,omitempty"`
+	Transport               Transport         `yaml:"transport,omitempty" json:"transport,omitempty"`
+	Log                     bool              `yaml:"log,omitempty" json:"log,omitempty"`
+	Delay                   Delay             `yaml:"delay,omitempty" json:"delay,omitempty"`
+	Fault                   Fault             `yaml:"fault,omitempty" json:"fault,omitempty"`
+	Throttle                Throttle          `yaml:"throttle,omitempty" json:"throttle,omitempty"`
+	Scenario                Scenario          `yaml:"scenario,omitempty" json:"scenario,omitempty"`
+	Record                  Record            `yaml:"record,omitempty" json:"record,omitempty"`
+	Replay                  Replay            `yaml:"replay,omitempty" json:"replay,omitempty"`
+	OpenAPI                 OpenAPI           `yaml:"openapi,omitempty" json:"openapi,omitempty"`
+	Validation              Validation        `yaml:"validation,omitempty" json:"validation,omitempty"`
+}
+
+// Query yaml structure. It can also be written as a plain string, which is the same as setting Equals
+type Query struct {
+	Equals  string   `yaml:"equals,omitempty" json:"equals,omitempty"`
+	Matches string   `yaml:"matches,omitempty" json:"matches,omitempty"`
+	Values  []string `yaml:"values,omitempty" json:"values,omitempty"`
+	Present *bool    `yaml:"present,omitempty" json:"present,omitempty"`
+}
+
+// UnmarshalYAML accepts both the plain string and the structured forms
+func (q *Query) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var equals string
+	if err := unmarshal(&equals); err == nil {
+		*q = Query{Equals: equals}
+		return nil
+	}
+
+	type plain Query
+	return unmarshal((*plain)(q))
+}
+
+// MarshalYAML writes the plain string form when only Equals is set
+func (q Query) MarshalYAML() (interface{}, error) {
+	if q.Matches == "" && len(q.Values) == 0 && q.Present == nil {
+		return q.Equals, nil
+	}
+
+	type plain Query
+	return plain(q), nil
+}
+
+// UnmarshalJSON accepts both the plain string and the structured forms
+func (q *Query) UnmarshalJSON(b []byte) error {
+	var equals string
+	if err := json.Unmarshal(b, &equals); err == nil {
+		*q = Query{Equals: equals}
+		return nil
+	}
+
+	type plain Query
+	return json.Unmarshal(b, (*plain)(q))
+}
+
+// MarshalJSON writes the plain string form when only Equals is set
+func (q Query) MarshalJSON() ([]byte, error) {
+	if q.Matches == "" && len(q.Values) == 0 && q.Present == nil {
+		return json.Marshal(q.Equals)
+	}
+
+	type plain Query
+	return json.Marshal(plain(q))
+}
+
+// ClientCert yaml structure. Matches the certificate of TLS clients: common-name must be equal to its
+// common name, and subject must match its subject (like CN=client,O=Example)
+type ClientCert struct {
+	CommonName string `yaml:"common-name,omitempty" json:"common-name,omitempty"`
+	Subject    string `yaml:"subject,omitempty" json:"subject,omitempty"`
+}
+
+// BodyMatcher yaml structure
+type BodyMatcher struct {
+	Equals   string     `yaml:"equals,omitempty" json:"equals,omitempty"`
+	Contains string     `yaml:"contains,omitempty" json:"contains,omitempty"`
+	JSONPath []JSONPath `yaml:"json-path,omitempty" json:"json-path,omitempty"`
+}
+
+// JSONPath yaml structure
+type JSONPath struct {
+	Path    string `yaml:"path,omitempty" json:"path,omitempty"`
+	Equals  string `yaml:"equals,omitempty" json:"equals,omitempty"`
+	Matches string `yaml:"matches,omitempty" json:"matches,omitempty"`
+	Exists  *bool  `yaml:"exists,omitempty" json:"exists,omitempty"`
 }
 
 // Rewrite yaml structure
 type Rewrite struct {
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
 }
 
 // Response yaml structure
 type Response struct {
-	Headers           map[string]string `yaml:"headers"`
-	Status            map[string]int    `yaml:"status"`
-	BodyType          string            `yaml:"body-type"`
-	Body              string            `yaml:"body"`
-	BodyFile          string            `yaml:"body-file"`
-	ResponseLib       string            `yaml:"response-lib"`
-	ResponseSymbol    string            `yaml:"response-symbol"`
-	MagicHeaderName   string            `yaml:"magic-header-name"`
-	MagicHeaderFolder string            `yaml:"magic-header-folder"`
+	Headers           map[string]string   `yaml:"headers,omitempty" json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `yaml:"multi-value-headers,omitempty" json:"multi-value-headers,omitempty"`
+	Status            map[string]int      `yaml:"status,omitempty" json:"status,omitempty"`
+	BodyType          string              `yaml:"body-type,omitempty" json:"body-type,omitempty"`
+	Body              string              `yaml:"body,omitempty" json:"body,omitempty"`
+	BodyFile          string              `yaml:"body-file,omitempty" json:"body-file,omitempty"`
+	ResponseLib       string              `yaml:"response-lib,omitempty" json:"response-lib,omitempty"`
+	ResponseSymbol    string              `yaml:"response-symbol,omitempty" json:"response-symbol,omitempty"`
+	Exec              Exec                `yaml:"exec,omitempty" json:"exec,omitempty"`
+	MagicHeaderName   string              `yaml:"magic-header-name,omitempty" json:"magic-header-name,omitempty"`
+	MagicHeaderFolder string              `yaml:"magic-header-folder,omitempty" json:"magic-header-folder,omitempty"`
+	StatusTemplate    string              `yaml:"status-template,omitempty" json:"status-template,omitempty"`
 }
 
+// ModifyRequest yaml structure. Changes the requests of pass parsers before they are proxied. Values are
+// templates, with the same data of template responses
+type ModifyRequest struct {
+	Host          string            `yaml:"host,omitempty" json:"host,omitempty"`
+	SetHeaders    map[string]string `yaml:"set-headers,omitempty" json:"set-headers,omitempty"`
+	AddHeaders    map[string]string `yaml:"add-headers,omitempty" json:"add-headers,omitempty"`
+	RemoveHeaders []string          `yaml:"remove-headers,omitempty" json:"remove-headers,omitempty"`
+	SetQuery      map[string]string `yaml:"set-query,omitempty" json:"set-query,omitempty"`
+	RemoveQuery   []string          `yaml:"remove-query,omitempty" json:"remove-query,omitempty"`
+}
+
+// ModifyResponse yaml structure. Changes the responses of pass parsers
+type ModifyResponse struct {
+	Status        int               `yaml:"status,omitempty" json:"status,omitempty"`
+	SetHeaders    map[string]string `yaml:"set-headers,omitempty" json:"set-headers,omitempty"`
+	RemoveHeaders []string          `yaml:"remove-headers,omitempty" json:"remove-headers,omitempty"`
+}
+
+// Transport yaml structure. Options of the connections of pass parsers to the upstreams. The CA bundle
+// is trusted besides the system CAs, and cert and key are the client certificate for mTLS upstreams
+type Transport struct {
+	CA                    string `yaml:"ca,omitempty" json:"ca,omitempty"`
+	InsecureSkipVerify    bool   `yaml:"insecure-skip-verify,omitempty" json:"insecure-skip-verify,omitempty"`
+	Cert                  string `yaml:"cert,omitempty" json:"cert,omitempty"`
+	Key                   string `yaml:"key,omitempty" json:"key,omitempty"`
+	DialTimeout           string `yaml:"dial-timeout,omitempty" json:"dial-timeout,omitempty"`
+	TLSHandshakeTimeout   string `yaml:"tls-handshake-timeout,omitempty" json:"tls-handshake-timeout,omitempty"`
+	ResponseHeaderTimeout string `yaml:"response-header-timeout,omitempty" json:"response-header-timeout,omitempty"`
+	Proxy                 string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	HTTP2                 *bool  `yaml:"http2,omitempty" json:"http2,omitempty"`
+	MaxIdleConns          int    `yaml:"max-idle-conns,omitempty" json:"max-idle-conns,omitempty"`
+	MaxIdleConnsPerHost   int    `yaml:"max-idle-conns-per-host,omitempty" json:"max-idle-conns-per-host,omitempty"`
+}
+
+// Upstream yaml structure. It can also be written as a plain string, which is the same as setting URI
+type Upstream struct {
+	URI    string `yaml:"uri,omitempty" json:"uri,omitempty"`
+	Weight int    `yaml:"weight,omitempty" json:"weight,omitempty"`
+}
+
+// UnmarshalYAML accepts both the plain string and the structured forms
+func (u *Upstream) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var uri string
+	if err := unmarshal(&uri); err == nil {
+		*u = Upstream{URI: uri}
+		return nil
+	}
+
+	type plain Upstream
+	return unmarshal((*plain)(u))
+}
+
+// UnmarshalJSON accepts both the plain string and the structured forms
+func (u *Upstream) UnmarshalJSON(b []byte) error {
+	var uri string
+	if err := json.Unmarshal(b, &uri); err == nil {
+		*u = Upstream{URI: uri}
+		return nil
+	}
+
+	type plain Upstream
+	return json.Unmarshal(b, (*plain)(u))
+}
+
+// LoadBalancing yaml structure. Strategy is round-robin (default), random, weighted or sticky
+type LoadBalancing struct {
+	Strategy     string      `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+	StickyHeader string      `yaml:"sticky-header,omitempty" json:"sticky-header,omitempty"`
+	Eject        Eject       `yaml:"eject,omitempty" json:"eject,omitempty"`
+	HealthCheck  HealthCheck `yaml:"health-check,omitempty" json:"health-check,omitempty"`
+}
+
+// Eject yaml structure. Upstreams failing Failures consecutive times are not used for Duration
+type Eject struct {
+	Failures int    `yaml:"failures,omitempty" json:"failures,omitempty"`
+	Duration string `yaml:"duration,omitempty" json:"duration,omitempty"`
+}
+
+// HealthCheck yaml structure
+type HealthCheck struct {
+	Path     string `yaml:"path,omitempty" json:"path,omitempty"`
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// Fallback yaml structure. The mock response served by pass parsers when the upstream can't be
+// reached, doesn't answer in time or answers one of the statuses
+type Fallback struct {
+	OnStatus []int    `yaml:"on-status,omitempty" json:"on-status,omitempty"`
+	Timeout  string   `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Response Response `yaml:"response,omitempty" json:"response,omitempty"`
+}
+
+// Exec yaml structure. The plugin is a command (run per request, or kept running as a worker) or
+// an HTTP endpoint
+type Exec struct {
+	Command []string `yaml:"command,omitempty" json:"command,omitempty"`
+	URL     string   `yaml:"url,omitempty" json:"url,omitempty"`
+	Worker  bool     `yaml:"worker,omitempty" json:"worker,omitempty"`
+	Timeout string   `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// Delay yaml structure. The distribution defaults to uniform between min and max. For the other
+// distributions, min and max (when set) bound the delays drawn
 type Delay struct {
-	Min string `yaml:"min"`
-	Max string `yaml:"max"`
+	Distribution string  `yaml:"distribution,omitempty" json:"distribution,omitempty"`
+	Min          string  `yaml:"min,omitempty" json:"min,omitempty"`
+	Max          string  `yaml:"max,omitempty" json:"max,omitempty"`
+	Value        string  `yaml:"value,omitempty" json:"value,omitempty"`
+	Mean         string  `yaml:"mean,omitempty" json:"mean,omitempty"`
+	StdDev       string  `yaml:"stddev,omitempty" json:"stddev,omitempty"`
+	Alpha        float64 `yaml:"alpha,omitempty" json:"alpha,omitempty"`
+	P50          string  `yaml:"p50,omitempty" json:"p50,omitempty"`
+	P95          string  `yaml:"p95,omitempty" json:"p95,omitempty"`
+	P99          string  `yaml:"p99,omitempty" json:"p99,omitempty"`
+	After        bool    `yaml:"after,omitempty" json:"after,omitempty"`
+}
+
+// Throttle yaml structure. Limits the speed the response body is sent, in chunks of chunk-size bytes,
+// and delays the first byte of the response
+type Throttle struct {
+	BytesPerSecond  int    `yaml:"bytes-per-second,omitempty" json:"bytes-per-second,omitempty"`
+	ChunkSize       int    `yaml:"chunk-size,omitempty" json:"chunk-size,omitempty"`
+	TimeToFirstByte string `yaml:"time-to-first-byte,omitempty" json:"time-to-first-byte,omitempty"`
+}
+
+// Fault yaml structure. Each fault is injected with its probability (from 0 to 1), and at most one fault
+// is injected per request
+type Fault struct {
+	Abort           AbortFault `yaml:"abort,omitempty" json:"abort,omitempty"`
+	CloseConnection float64    `yaml:"close-connection,omitempty" json:"close-connection,omitempty"`
+	Reset           float64    `yaml:"reset,omitempty" json:"reset,omitempty"`
+	TruncateBody    float64    `yaml:"truncate-body,omitempty" json:"truncate-body,omitempty"`
+	Hang            HangFault  `yaml:"hang,omitempty" json:"hang,omitempty"`
+	Garbage         float64    `yaml:"garbage,omitempty" json:"garbage,omitempty"`
+}
+
+// AbortFault yaml structure. Answers the status instead of the response
+type AbortFault struct {
+	Probability float64 `yaml:"probability,omitempty" json:"probability,omitempty"`
+	Status      int     `yaml:"status,omitempty" json:"status,omitempty"`
+}
+
+// HangFault yaml structure. Sends the response headers, then waits for the duration before closing the
+// connection
+type HangFault struct {
+	Probability float64 `yaml:"probability,omitempty" json:"probability,omitempty"`
+	Duration    string  `yaml:"duration,omitempty" json:"duration,omitempty"`
+}
+
+// Scenario yaml structure
+type Scenario struct {
+	Name          string `yaml:"name,omitempty" json:"name,omitempty"`
+	RequiredState string `yaml:"required-state,omitempty" json:"required-state,omitempty"`
+	NewState      string `yaml:"new-state,omitempty" json:"new-state,omitempty"`
+}
+
+// Record yaml structure
+type Record struct {
+	Dir     string   `yaml:"dir,omitempty" json:"dir,omitempty"`
+	Headers []string `yaml:"headers,omitempty" json:"headers,omitempty"`
+}
+
+// Replay yaml structure
+type Replay struct {
+	Dir         string   `yaml:"dir,omitempty" json:"dir,omitempty"`
+	Fingerprint []string `yaml:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+	Headers     []string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Fallback    bool     `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+}
+
+// OpenAPI yaml structure
+type OpenAPI struct {
+	Spec     string `yaml:"spec,omitempty" json:"spec,omitempty"`
+	BasePath string `yaml:"base-path,omitempty" json:"base-path,omitempty"`
+}
+
+// Validation yaml structure. Mode is reject (responds 400) or log (only records the violations)
+type Validation struct {
+	Spec     string `yaml:"spec,omitempty" json:"spec,omitempty"`
+	BasePath string `yaml:"base-path,omitempty" json:"base-path,omitempty"`
+	Mode     string `yaml:"mode,omitempty" json:"mode,omitempty"`
 }
@@ -0,0 +1,569 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	yamlv2 "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ValidationError is a configuration error, located by service index and YAML line
+type ValidationError struct {
+	Service int
+	Field   string
+	Line    int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	var b strings.Builder
+	if e.Service >= 0 {
+		fmt.Fprintf(&b, "services[%d].parser", e.Service)
+		if e.Field != "" {
+			fmt.Fprintf(&b, ".%s", e.Field)
+		}
+	} else if e.Field != "" {
+		b.WriteString(e.Field)
+	}
+
+	if e.Line > 0 {
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "(line %d)", e.Line)
+	}
+
+	if b.Len() > 0 {
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Message)
+
+	return b.String()
+}
+
+// ValidationErrors is the list of errors found in a configuration
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d configuration error(s):\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// Load reads, parses and validates a configuration file. Unknown keys are rejected
+func Load(path string) (Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error while reading config file: %w", err)
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates a YAML configuration. Unknown keys are rejected
+func Parse(b []byte) (Config, error) {
+	var c Config
+	if err := yamlv2.UnmarshalStrict(b, &c); err != nil {
+		return Config{}, fmt.Errorf("error while unmarshalling yml: %w", err)
+	}
+
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &root); err != nil {
+		return Config{}, fmt.Errorf("error while unmarshalling yml: %w", err)
+	}
+
+	if errs := Validate(c); len(errs) > 0 {
+		for i := range errs {
+			errs[i].Line = errs[i].line(&root)
+		}
+		return Config{}, errs
+	}
+
+	return c, nil
+}
+
+// line returns the YAML line of the field of the error, or of its closest parent found
+func (e ValidationError) line(root *yamlv3.Node) int {
+	path := []string{}
+	if e.Service >= 0 {
+		path = append(path, "services", fmt.Sprint(e.Service), "parser")
+	}
+	if e.Field != "" {
+		path = append(path, strings.Split(e.Field, ".")...)
+	}
+
+	node := root
+	if node.Kind == yamlv3.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	line := node.Line
+	for _, key := range path {
+		node = childNode(node, key)
+		if node == nil {
+			break
+		}
+		line = node.Line
+	}
+
+	return line
+}
+
+func childNode(node *yamlv3.Node, key string) *yamlv3.Node {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return node.Content[i+1]
+			}
+		}
+	case yamlv3.SequenceNode:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node.Content) {
+			return node.Content[i]
+		}
+	}
+
+	return nil
+}
+
+var (
+	parserTypes         = []string{"mock", "pass", "replay", "openapi"}
+	bodyTypes           = []string{"fixed", "echo", "template", "script", "exec", "runnable"}
+	validateModes       = []string{"reject", "log"}
+	balancingStrategies = []string{"round-robin", "random", "weighted", "sticky"}
+	delayDistributions  = []string{"fixed", "uniform", "normal", "lognormal", "pareto", "percentiles"}
+	clientAuthModes     = []string{"request", "require"}
+	httpMethodsRe       = regexp.MustCompile(`^[A-Z]+$`)
+)
+
+// Validate checks a configuration, returning all errors found. Lines are not set, since the
+// configuration may not come from a file
+func Validate(c Config) ValidationErrors {
+	tv := validator{service: -1}
+	tv.tls(c.TLS)
+
+	errs := tv.errs
+	for i, service := range c.Services {
+		v := validator{service: i}
+		v.parser(service.Parser)
+		errs = append(errs, v.errs...)
+	}
+
+	return errs
+}
+
+type validator struct {
+	service int
+	errs    ValidationErrors
+}
+
+func (v *validator) add(field string, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		Service: v.service,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) regex(field string, expr string) {
+	if _, err := regexp.Compile(expr); err != nil {
+		v.add(field, "invalid regex: %v", err)
+	}
+}
+
+func (v *validator) file(field string, path string, dir bool) {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		v.add(field, "%v", err)
+	case dir && !info.IsDir():
+		v.add(field, "%s is not a folder", path)
+	case !dir && info.IsDir():
+		v.add(field, "%s is a folder", path)
+	}
+}
+
+func (v *validator) duration(field string, d string) {
+	if _, err := time.ParseDuration(d); err != nil {
+		v.add(field, "invalid duration: %v", err)
+	}
+}
+
+// optionalDuration checks a duration only when it is set
+func (v *validator) optionalDuration(field string, d string) {
+	if d != "" {
+		v.duration(field, d)
+	}
+}
+
+func (v *validator) parser(p Parser) {
+	// openapi services are expanded to mock services from the spec
+	if p.ConfigType == "openapi" {
+		if p.OpenAPI.Spec == "" {
+			v.add("openapi.spec", "openapi spec is required")
+		} else {
+			v.file("openapi.spec", p.OpenAPI.Spec, false)
+		}
+		v.validation(p, true)
+		return
+	}
+
+	v.validation(p, false)
+
+	if p.Pattern == "" {
+		v.add("pattern", "pattern is required")
+	} else {
+		v.regex("pattern", p.Pattern)
+	}
+
+	if len(p.Methods) == 0 {
+		v.add("methods", "at least one method is required")
+	}
+	for i, m := range p.Methods {
+		if !httpMethodsRe.MatchString(m) {
+			v.add(fmt.Sprintf("methods.%d", i), "invalid method %s", m)
+		}
+	}
+
+	for name, q := range p.Query {
+		if q.Matches != "" {
+			v.regex("query."+name+".matches", q.Matches)
+		}
+	}
+
+	if p.ClientCert.Subject != "" {
+		v.regex("client-cert.subject", p.ClientCert.Subject)
+	}
+
+	for i, jp := range p.Body.JSONPath {
+		if !strings.HasPrefix(jp.Path, "$") {
+			v.add(fmt.Sprintf("body.json-path.%d.path", i), "json path must start with $")
+		}
+		if jp.Matches != "" {
+			v.regex(fmt.Sprintf("body.json-path.%d.matches", i), jp.Matches)
+		}
+	}
+
+	v.delay(p)
+
+	v.fault(p.Fault)
+	v.throttle(p.Throttle)
+
+	switch p.ConfigType {
+	case "mock":
+		v.response("response", p.Methods, p.Response)
+	case "pass", "replay":
+		v.pass(p)
+	case "":
+		v.add("type", "type is required")
+	default:
+		v.add("type", "bad value %s, expected one of %s", p.ConfigType, strings.Join(parserTypes, ", "))
+	}
+}
+
+// validation checks the request validation. The spec of openapi services is used when none is set
+func (v *validator) validation(p Parser, openapi bool) {
+	val := p.Validation
+	if val.Spec == "" && val.Mode == "" && val.BasePath == "" {
+		return
+	}
+
+	switch val.Mode {
+	case "reject", "log":
+	case "":
+		v.add("validation.mode", "validation mode is required")
+	default:
+		v.add("validation.mode", "bad value %s, expected one of %s", val.Mode, strings.Join(validateModes, ", "))
+	}
+
+	switch {
+	case val.Spec != "":
+		v.file("validation.spec", val.Spec, false)
+	case !openapi:
+		v.add("validation.spec", "validation spec is required")
+	}
+}
+
+// response checks a mock response, located at field
+func (v *validator) response(field string, methods []string, r Response) {
+	if r.StatusTemplate == "" {
+		for _, m := range methods {
+			if _, ok := r.Status[m]; !ok {
+				v.add(field+".status", "missing status for method %s", m)
+			}
+		}
+	}
+
+	switch r.BodyType {
+	case "fixed", "template", "script":
+		if r.BodyFile != "" {
+			v.file(field+".body-file", r.BodyFile, false)
+		}
+		if r.MagicHeaderFolder != "" {
+			v.file(field+".magic-header-folder", r.MagicHeaderFolder, true)
+		}
+	case "echo":
+	case "exec":
+		v.exec(field+".exec", r.Exec)
+	case "runnable":
+		if r.ResponseLib == "" || r.ResponseSymbol == "" {
+			v.add(field, "response-lib and response-symbol are required for runnable responses")
+		} else {
+			v.file(field+".response-lib", r.ResponseLib, false)
+		}
+	case "":
+		v.add(field+".body-type", "body-type is required")
+	default:
+		v.add(field+".body-type", "bad value %s, expected one of %s", r.BodyType, strings.Join(bodyTypes, ", "))
+	}
+}
+
+func (v *validator) pass(p Parser) {
+	if p.ConfigType == "pass" || p.Replay.Fallback {
+		switch {
+		case p.PassBaseURI == "" && len(p.PassBaseURIs) == 0:
+			v.add("pass-base-uri", "pass-base-uri is required")
+		case p.PassBaseURI != "" && len(p.PassBaseURIs) > 0:
+			v.add("pass-base-uris", "only one of pass-base-uri and pass-base-uris can be set")
+		}
+	}
+
+	v.loadBalancing(p)
+
+	if p.ConfigType == "replay" && p.Replay.Dir == "" {
+		v.add("replay.dir", "replay dir is required")
+	}
+
+	for i, rw := range p.Rewrites {
+		v.regex(fmt.Sprintf("rewrite.%d.source", i), rw.Source)
+	}
+
+	if p.TransformLib != "" {
+		v.file("transform-lib", p.TransformLib, false)
+	} else if p.TransformResponseSymbol != "" {
+		v.add("transform-lib", "transform-lib is required for transform-response-symbol")
+	}
+	if strings.HasSuffix(p.TransformLib, ".wasm") && p.TransformResponseSymbol != "" {
+		v.add("transform-response-symbol", "transform-response-symbol is only available for Go plugins")
+	}
+
+	if s := p.ModifyResponse.Status; s != 0 && (s < 100 || s > 599) {
+		v.add("modify-response.status", "invalid status %d", s)
+	}
+
+	if p.Fallback.Response.BodyType != "" || len(p.Fallback.OnStatus) > 0 || p.Fallback.Timeout != "" {
+		v.response("fallback.response", p.Methods, p.Fallback.Response)
+		v.optionalDuration("fallback.timeout", p.Fallback.Timeout)
+	}
+
+	if len(p.TransformExec.Command) > 0 || p.TransformExec.URL != "" {
+		v.exec("transform-exec", p.TransformExec)
+	}
+
+	v.transport(p.Transport)
+}
+
+func (v *validator) transport(t Transport) {
+	if t.CA != "" {
+		v.file("transport.ca", t.CA, false)
+	}
+
+	switch {
+	case (t.Cert == "") != (t.Key == ""):
+		v.add("transport", "cert and key must be set together")
+	case t.Cert != "":
+		v.file("transport.cert", t.Cert, false)
+		v.file("transport.key", t.Key, false)
+	}
+
+	v.optionalDuration("transport.dial-timeout", t.DialTimeout)
+	v.optionalDuration("transport.tls-handshake-timeout", t.TLSHandshakeTimeout)
+	v.optionalDuration("transport.response-header-timeout", t.ResponseHeaderTimeout)
+
+	if t.Proxy != "" {
+		if u, err := url.Parse(t.Proxy); err != nil || u.Host == "" {
+			v.add("transport.proxy", "invalid proxy url %q", t.Proxy)
+		}
+	}
+
+	if t.MaxIdleConns < 0 {
+		v.add("transport.max-idle-conns", "max-idle-conns can't be negative")
+	}
+	if t.MaxIdleConnsPerHost < 0 {
+		v.add("transport.max-idle-conns-per-host", "max-idle-conns-per-host can't be negative")
+	}
+}
+
+func (v *validator) loadBalancing(p Parser) {
+	for i, u := range p.PassBaseURIs {
+		if uri, err := url.Parse(u.URI); err != nil || uri.Host == "" {
+			v.add(fmt.Sprintf("pass-base-uris.%d", i), "invalid uri %q", u.URI)
+		}
+		if u.Weight < 0 {
+			v.add(fmt.Sprintf("pass-base-uris.%d.weight", i), "weight can't be negative")
+		}
+	}
+
+	lb := p.LoadBalancing
+	switch lb.Strategy {
+	case "", "round-robin", "random", "weighted":
+	case "sticky":
+		if lb.StickyHeader == "" {
+			v.add("load-balancing.sticky-header", "sticky-header is required for the sticky strategy")
+		}
+	default:
+		v.add("load-balancing.strategy", "bad value %s, expected one of %s", lb.Strategy, strings.Join(balancingStrategies, ", "))
+	}
+
+	v.optionalDuration("load-balancing.eject.duration", lb.Eject.Duration)
+	v.optionalDuration("load-balancing.health-check.interval", lb.HealthCheck.Interval)
+	v.optionalDuration("load-balancing.health-check.timeout", lb.HealthCheck.Timeout)
+}
+
+// tls checks the top level TLS configuration
+func (v *validator) tls(t TLS) {
+	switch {
+	case t.Cert != "" && t.Auto:
+		v.add("tls.auto", "only one of cert and auto can be set")
+	case (t.Cert == "") != (t.Key == ""):
+		v.add("tls", "cert and key must be set together")
+	case t.Cert != "":
+		v.file("tls.cert", t.Cert, false)
+		v.file("tls.key", t.Key, false)
+	}
+
+	if !t.Enabled() && (len(t.Hosts) > 0 || t.CADir != "" || t.ClientCA != "" || t.ClientAuth != "") {
+		v.add("tls", "cert and key, or auto, are required")
+	}
+
+	switch t.ClientAuth {
+	case "", "request", "require":
+	default:
+		v.add("tls.client-auth", "bad value %s, expected one of %s", t.ClientAuth, strings.Join(clientAuthModes, ", "))
+	}
+
+	if t.ClientCA != "" {
+		v.file("tls.client-ca", t.ClientCA, false)
+	} else if t.ClientAuth != "" {
+		v.add("tls.client-ca", "client-ca is required for client-auth")
+	}
+}
+
+// delay checks the delay distribution and the durations it requires
+func (v *validator) delay(p Parser) {
+	d := p.Delay
+	required := func(field string, value string) {
+		if value == "" {
+			v.add("delay."+field, "%s is required for the %s distribution", field, d.Distribution)
+		}
+	}
+
+	switch d.Distribution {
+	case "":
+		if d.Min != "" || d.Max != "" {
+			v.duration("delay.min", d.Min)
+			v.duration("delay.max", d.Max)
+		}
+	case "uniform":
+		required("min", d.Min)
+		required("max", d.Max)
+	case "fixed":
+		required("value", d.Value)
+	case "normal", "lognormal":
+		required("mean", d.Mean)
+		required("stddev", d.StdDev)
+	case "pareto":
+		required("min", d.Min)
+		if d.Alpha <= 0 {
+			v.add("delay.alpha", "alpha must be greater than 0")
+		}
+	case "percentiles":
+		required("p50", d.P50)
+		if d.P95 == "" && d.P99 == "" {
+			v.add("delay", "p95 or p99 is required for the percentiles distribution")
+		}
+	default:
+		v.add("delay.distribution", "bad value %s, expected one of %s", d.Distribution, strings.Join(delayDistributions, ", "))
+	}
+
+	if d.Distribution != "" {
+		v.optionalDuration("delay.min", d.Min)
+		v.optionalDuration("delay.max", d.Max)
+		v.optionalDuration("delay.value", d.Value)
+		v.optionalDuration("delay.mean", d.Mean)
+		v.optionalDuration("delay.stddev", d.StdDev)
+		v.optionalDuration("delay.p50", d.P50)
+		v.optionalDuration("delay.p95", d.P95)
+		v.optionalDuration("delay.p99", d.P99)
+	}
+
+	if d.After && p.ConfigType != "pass" {
+		v.add("delay.after", "after is only available for pass parsers")
+	}
+}
+
+func (v *validator) throttle(t Throttle) {
+	if t.BytesPerSecond < 0 {
+		v.add("throttle.bytes-per-second", "bytes-per-second can't be negative")
+	}
+	if t.ChunkSize < 0 {
+		v.add("throttle.chunk-size", "chunk-size can't be negative")
+	} else if t.ChunkSize > 0 && t.BytesPerSecond == 0 {
+		v.add("throttle.chunk-size", "bytes-per-second is required for chunk-size")
+	}
+	v.optionalDuration("throttle.time-to-first-byte", t.TimeToFirstByte)
+}
+
+func (v *validator) fault(f Fault) {
+	probabilities := []struct {
+		field string
+		value float64
+	}{
+		{"fault.abort.probability", f.Abort.Probability},
+		{"fault.close-connection", f.CloseConnection},
+		{"fault.reset", f.Reset},
+		{"fault.truncate-body", f.TruncateBody},
+		{"fault.hang.probability", f.Hang.Probability},
+		{"fault.garbage", f.Garbage},
+	}
+
+	total := 0.0
+	for _, p := range probabilities {
+		if p.value < 0 || p.value > 1 {
+			v.add(p.field, "probability must be between 0 and 1")
+		}
+		total += p.value
+	}
+	// the tolerance avoids rejecting sums like 0.1 + 0.2 + 0.7 due to rounding
+	if total > 1+1e-9 {
+		v.add("fault", "the sum of the fault probabilities can't be greater than 1")
+	}
+
+	if f.Abort.Probability > 0 && (f.Abort.Status < 100 || f.Abort.Status > 599) {
+		v.add("fault.abort.status", "invalid status %d", f.Abort.Status)
+	}
+	v.optionalDuration("fault.hang.duration", f.Hang.Duration)
+}
+
+func (v *validator) exec(field string, e Exec) {
+	switch {
+	case len(e.Command) == 0 && e.URL == "":
+		v.add(field, "command or url is required")
+	case len(e.Command) > 0 && e.URL != "":
+		v.add(field, "only one of command and url can be set")
+	case e.URL != "" && e.Worker:
+		v.add(field+".worker", "worker mode is only available for commands")
+	}
+
+	v.optionalDuration(field+".timeout", e.Timeout)
+}
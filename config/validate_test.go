@@ -0,0 +1,303 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+func Test_Parse(t *testing.T) {
+	type test struct {
+		name    string
+		yml     string
+		wantErr []string
+	}
+
+	tests := []test{
+		{
+			name: "valid",
+			yml: `
+services:
+  - parser:
+      pattern: /ping
+      methods: [ GET ]
+      type: mock
+      response:
+        status:
+          GET: 200
+        body-type: fixed
+        body: pong
+`,
+		},
+		{
+			name: "unknown key",
+			yml: `
+services:
+  - parser:
+      patern: /ping
+`,
+			wantErr: []string{"line 4: field patern not found"},
+		},
+		{
+			name: "invalid values",
+			yml: `
+services:
+  - parser:
+      pattern: /ping
+      methods: [ GET ]
+      type: mock
+      response:
+        status:
+          GET: 200
+        body-type: fixed
+  - parser:
+      pattern: /bad(
+      methods: [ GET, POST ]
+      type: mock
+      delay:
+        min: 1s
+        max: 2
+      response:
+        status:
+          GET: 200
+        body-type: fixed
+        body-file: testdata/missing.json
+  - parser:
+      pattern: /pass
+      methods: [ GET ]
+      type: pass
+      rewrite:
+        - source: /pass(/.*
+          target: $1
+`,
+			wantErr: []string{
+				"services[1].parser.pattern (line 12): invalid regex",
+				"services[1].parser.delay.max (line 17): invalid duration",
+				"services[1].parser.response.status (line 20): missing status for method POST",
+				"services[1].parser.response.body-file (line 22): stat testdata/missing.json",
+				"services[2].parser.pass-base-uri (line 24): pass-base-uri is required",
+				"services[2].parser.rewrite.0.source (line 28): invalid regex",
+			},
+		},
+		{
+			name: "openapi",
+			yml: `
+services:
+  - parser:
+      type: openapi
+      openapi:
+        spec: testdata/missing.yml
+`,
+			wantErr: []string{"services[0].parser.openapi.spec (line 6): stat testdata/missing.yml"},
+		},
+		{
+			name: "validation",
+			yml: `
+services:
+  - parser:
+      pattern: /ping
+      methods: [ GET ]
+      type: pass
+      pass-base-uri: http://localhost
+      validation:
+        mode: strict
+`,
+			wantErr: []string{
+				"services[0].parser.validation.mode (line 9): bad value strict, expected one of reject, log",
+				"services[0].parser.validation.spec (line 9): validation spec is required",
+			},
+		},
+		{
+			name: "exec",
+			yml: `
+services:
+  - parser:
+      pattern: /ping
+      methods: [ GET ]
+      type: mock
+      response:
+        status:
+          GET: 200
+        body-type: exec
+        exec:
+          url: http://localhost:9000
+          worker: true
+          timeout: soon
+`,
+			wantErr: []string{
+				"services[0].parser.response.exec.worker (line 13): worker mode is only available for commands",
+				"services[0].parser.response.exec.timeout (line 14): invalid duration",
+			},
+		},
+		{
+			name: "pass fallback",
+			yml: `
+services:
+  - parser:
+      pattern: /api
+      methods: [ GET, POST ]
+      type: pass
+      pass-base-uri: http://localhost
+      fallback:
+        timeout: 1s
+        response:
+          status:
+            GET: 200
+          body-type: fixed
+`,
+			wantErr: []string{"services[0].parser.fallback.response.status (line 12): missing status for method POST"},
+		},
+		{
+			name: "pass load balancing",
+			yml: `
+services:
+  - parser:
+      pattern: /api
+      methods: [ GET ]
+      type: pass
+      pass-base-uris:
+        - http://localhost:8081
+        - uri: localhost
+          weight: -1
+      load-balancing:
+        strategy: sticky
+        eject:
+          duration: soon
+`,
+			wantErr: []string{
+				`services[0].parser.pass-base-uris.1 (line 9): invalid uri "localhost"`,
+				"services[0].parser.pass-base-uris.1.weight (line 10): weight can't be negative",
+				"services[0].parser.load-balancing.sticky-header (line 12): sticky-header is required",
+				"services[0].parser.load-balancing.eject.duration (line 14): invalid duration",
+			},
+		},
+		{
+			name: "fault",
+			yml: `
+services:
+  - parser:
+      pattern: /api
+      methods: [ GET ]
+      type: mock
+      response:
+        status:
+          GET: 200
+        body-type: echo
+      fault:
+        abort:
+          probability: 0.6
+        reset: 1.5
+`,
+			wantErr: []string{
+				"services[0].parser.fault.reset (line 14): probability must be between 0 and 1",
+				"services[0].parser.fault (line 12): the sum of the fault probabilities can't be greater than 1",
+				"services[0].parser.fault.abort.status (line 13): invalid status 0",
+			},
+		},
+		{
+			name: "delay",
+			yml: `
+services:
+  - parser:
+      pattern: /api
+      methods: [ GET ]
+      type: mock
+      response:
+        status:
+          GET: 200
+        body-type: echo
+      delay:
+        distribution: lognormal
+        mean: 100ms
+        after: true
+`,
+			wantErr: []string{
+				"services[0].parser.delay.stddev (line 12): stddev is required for the lognormal distribution",
+				"services[0].parser.delay.after (line 14): after is only available for pass parsers",
+			},
+		},
+		{
+			name: "throttle",
+			yml: `
+services:
+  - parser:
+      pattern: /api
+      methods: [ GET ]
+      type: pass
+      pass-base-uri: http://localhost
+      throttle:
+        chunk-size: 512
+        time-to-first-byte: 1 second
+`,
+			wantErr: []string{
+				"services[0].parser.throttle.chunk-size (line 9): bytes-per-second is required for chunk-size",
+				"services[0].parser.throttle.time-to-first-byte (line 10): invalid duration",
+			},
+		},
+		{
+			name: "tls",
+			yml: `
+tls:
+  cert: testdata/missing.pem
+  client-auth: always
+services:
+  - parser:
+      pattern: /api
+      methods: [ GET ]
+      type: mock
+      client-cert:
+        subject: CN=(
+      response:
+        status:
+          GET: 200
+        body-type: echo
+`,
+			wantErr: []string{
+				"tls (line 3): cert and key must be set together",
+				"tls.client-auth (line 4): bad value always, expected one of request, require",
+				"tls.client-ca (line 3): client-ca is required for client-auth",
+				"services[0].parser.client-cert.subject (line 11): invalid regex",
+			},
+		},
+		{
+			name: "pass transport",
+			yml: `
+services:
+  - parser:
+      pattern: /api
+      methods: [ GET ]
+      type: pass
+      pass-base-uri: https://localhost
+      transport:
+        cert: testdata/missing.pem
+        dial-timeout: 5
+        proxy: proxy.local
+        http2: false
+`,
+			wantErr: []string{
+				"services[0].parser.transport (line 9): cert and key must be set together",
+				"services[0].parser.transport.dial-timeout (line 10): invalid duration",
+				`services[0].parser.transport.proxy (line 11): invalid proxy url "proxy.local"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			_, err := config.Parse([]byte(tt.yml))
+			if len(tt.wantErr) == 0 {
+				assert.NoError(err)
+				return
+			}
+
+			assert.Error(err)
+			for _, want := range tt.wantErr {
+				assert.Contains(err.Error(), want)
+			}
+		})
+	}
+}
@@ -1,9 +1,18 @@
 module github.com/rodrigo-kayala/mirage-mocker
 
-go 1.15
+go 1.21
 
 require (
 	github.com/rs/zerolog v1.21.0
 	github.com/stretchr/testify v1.7.0
+	github.com/tetratelabs/wazero v1.8.2
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 )
@@ -1,8 +1,9 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"time"
@@ -11,31 +12,98 @@ import (
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v2"
 
+	"github.com/rodrigo-kayala/mirage-mocker/certs"
 	"github.com/rodrigo-kayala/mirage-mocker/config"
+	"github.com/rodrigo-kayala/mirage-mocker/openapi"
 	"github.com/rodrigo-kayala/mirage-mocker/processor"
 )
 
 // LoadConfig loads yaml configuration
 func loadConfig(configPath string) config.Config {
-	b, err := ioutil.ReadFile(configPath)
+	m, err := config.Load(configPath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("error while reading config file")
+		log.Fatal().Err(err).Msg("error loading config")
 	}
 
-	m := config.Config{}
-	err = yaml.Unmarshal(b, &m)
+	return m
+}
+
+// validate checks a configuration file, printing the errors found
+func validate(configPath string) {
+	if _, err := config.Load(configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s is valid\n", configPath)
+}
+
+// importOpenAPI prints a configuration with mock services for every operation of an OpenAPI spec
+func importOpenAPI(args []string) {
+	fs := flag.NewFlagSet("import openapi", flag.ExitOnError)
+	basePath := fs.String("base-path", "", "path prefix of the generated patterns (default: path of the first server URL)")
+	name := fs.String("name", "", "prefix of the generated service names")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
 
+	spec, err := openapi.Load(fs.Arg(0))
 	if err != nil {
-		log.Fatal().Err(err).Msg("error while unmarshalling yml")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	return m
+	if *basePath == "" {
+		*basePath = spec.ServersBasePath()
+	}
+
+	services, err := spec.Services(config.Parser{Name: *name}, *basePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	b, err := yaml.Marshal(config.Config{Services: services})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	_, _ = os.Stdout.Write(b)
 }
 
 func main() {
+	watch := flag.Bool("watch", false, "reload the configuration when it (or a file it references) changes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [--watch] [config-file]\n       %s validate <config-file>\n       %s import openapi [--base-path path] [--name name] <spec-file>\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.Arg(0) == "validate" {
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		validate(flag.Arg(1))
+		return
+	}
+
+	if flag.Arg(0) == "import" {
+		if flag.Arg(1) != "openapi" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		importOpenAPI(flag.Args()[2:])
+		return
+	}
+
 	configFile := "mocker.yml"
-	if len(os.Args) > 1 {
-		configFile = os.Args[1]
+	if flag.NArg() > 0 {
+		configFile = flag.Arg(0)
 	}
 
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
@@ -55,11 +123,60 @@ func main() {
 		log.Fatal().Err(err).Msg("error creating processor")
 	}
 
+	if *watch {
+		go watchConfig(configFile, rp, time.Second)
+	}
+
 	port := 8080
 	if c.Port > 0 {
 		port = c.Port
 	}
 
-	http.HandleFunc("/", rp.Process)
-	log.Fatal().Err(http.ListenAndServe(fmt.Sprintf(":%d", port), nil)).Msg("error serving http")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rp.Process)
+	servers := []*http.Server{{Addr: fmt.Sprintf(":%d", port), Handler: mux}}
+
+	if c.TLS.Enabled() {
+		tlsConfig, err := certs.ServerConfig(c.TLS)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error configuring tls")
+		}
+		servers[0].TLSConfig = tlsConfig
+
+		if c.TLS.Auto {
+			log.Info().Msgf("serving https with a certificate of the local CA, add %s to the trust stores", certs.CAPath(c.TLS))
+		}
+	}
+
+	// the admin API is served on its own port when configured, otherwise under the reserved prefix
+	if c.AdminPort > 0 {
+		servers = append(servers, &http.Server{Addr: fmt.Sprintf(":%d", c.AdminPort), Handler: rp.Admin()})
+	} else {
+		mux.Handle(processor.AdminPrefix+"/", rp.Admin())
+	}
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if server.TLSConfig != nil {
+				errs <- server.ListenAndServeTLS("", "")
+				return
+			}
+			errs <- server.ListenAndServe()
+		}(server)
+	}
+
+	select {
+	case err := <-errs:
+		log.Fatal().Err(err).Msg("error serving http")
+	case <-rp.Done():
+		log.Info().Msg("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				log.Error().Err(err).Msg("error shutting down server")
+			}
+		}
+	}
 }
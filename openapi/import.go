@@ -0,0 +1,246 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+var (
+	pathParamRe     = regexp.MustCompile(`\{([^}]+)\}`)
+	unsafeGroupChar = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// PathPattern converts an OpenAPI path template like /users/{id} to a pattern like ^/users/(?P<id>[^/]+)$
+func PathPattern(basePath string, path string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	b.WriteString(regexp.QuoteMeta(strings.TrimSuffix(basePath, "/")))
+
+	last := 0
+	for _, m := range pathParamRe.FindAllStringSubmatchIndex(path, -1) {
+		b.WriteString(regexp.QuoteMeta(path[last:m[0]]))
+		name := unsafeGroupChar.ReplaceAllString(path[m[2]:m[3]], "_")
+		fmt.Fprintf(&b, "(?P<%s>[^/]+)", name)
+		last = m[1]
+	}
+	b.WriteString(regexp.QuoteMeta(path[last:]))
+	b.WriteString("$")
+
+	return b.String()
+}
+
+// ServersBasePath returns the path of the first server URL of the spec, used as base path
+func (s *Spec) ServersBasePath() string {
+	if len(s.Servers) == 0 {
+		return ""
+	}
+
+	u, err := url.Parse(s.Servers[0].URL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// SortedPaths returns the spec paths, with concrete paths before templated ones (so /users/me is
+// matched before /users/{id}) and otherwise in alphabetical order
+func (s *Spec) SortedPaths() []string {
+	paths := make([]string, 0, len(s.Paths))
+	for p := range s.Paths {
+		paths = append(paths, p)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		si, sj := strings.Split(paths[i], "/"), strings.Split(paths[j], "/")
+		for k := 0; k < len(si) && k < len(sj); k++ {
+			ti, tj := strings.Contains(si[k], "{"), strings.Contains(sj[k], "{")
+			if ti != tj {
+				return tj
+			}
+		}
+		return paths[i] < paths[j]
+	})
+
+	return paths
+}
+
+var methodsOrder = []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE"}
+
+// Services creates a mock service for each operation of the spec. Attributes of base (like log, delay,
+// headers and validation) are copied to every service, and its name is used as prefix of the service names
+func (s *Spec) Services(base config.Parser, basePath string) ([]config.Service, error) {
+	var services []config.Service
+	for _, path := range s.SortedPaths() {
+		ops := s.Paths[path].Operations()
+		for _, method := range methodsOrder {
+			op, ok := ops[method]
+			if !ok {
+				continue
+			}
+
+			p, err := s.mockParser(base, basePath, path, method, op)
+			if err != nil {
+				return nil, fmt.Errorf("error importing %s %s: %w", method, path, err)
+			}
+			services = append(services, config.Service{Parser: p})
+		}
+	}
+
+	return services, nil
+}
+
+func (s *Spec) mockParser(base config.Parser, basePath string, path string, method string, op *Operation) (config.Parser, error) {
+	p := config.Parser{
+		Pattern:    PathPattern(basePath, path),
+		Methods:    []string{method},
+		Headers:    base.Headers,
+		ConfigType: "mock",
+		Log:        base.Log,
+		Delay:      base.Delay,
+		Validation: base.Validation,
+	}
+
+	name := op.OperationID
+	if name == "" {
+		name = method + " " + path
+	}
+	if base.Name != "" {
+		name = base.Name + "." + name
+	}
+	p.Name = name
+
+	code, resp, err := s.successResponse(op)
+	if err != nil {
+		return config.Parser{}, err
+	}
+
+	p.Response = config.Response{
+		Status:   map[string]int{method: code},
+		BodyType: "fixed",
+	}
+
+	if resp == nil {
+		return p, nil
+	}
+
+	contentType, media := preferredMedia(resp.Content)
+	if media == nil {
+		return p, nil
+	}
+
+	body, err := s.mediaSample(media)
+	if err != nil {
+		return config.Parser{}, err
+	}
+
+	p.Response.Headers = map[string]string{"content-type": contentType}
+	p.Response.Body, err = encodeBody(contentType, body)
+	if err != nil {
+		return config.Parser{}, err
+	}
+
+	return p, nil
+}
+
+// successResponse returns the first 2xx response of an operation (or the default one, as 200)
+func (s *Spec) successResponse(op *Operation) (int, *Response, error) {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	pick := func(code string) (int, *Response, error) {
+		resp, err := s.ResolveResponse(op.Responses[code])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			// ranges like 2XX and default
+			status = 200
+		}
+		return status, resp, nil
+	}
+
+	for _, code := range codes {
+		if strings.HasPrefix(code, "2") {
+			return pick(code)
+		}
+	}
+
+	if _, ok := op.Responses["default"]; ok {
+		return pick("default")
+	}
+
+	return 200, nil, nil
+}
+
+// preferredMedia returns the JSON media type if there is one, otherwise the first one
+func preferredMedia(content map[string]*MediaType) (string, *MediaType) {
+	types := make([]string, 0, len(content))
+	for t := range content {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, t := range types {
+		if isJSON(t) {
+			return t, content[t]
+		}
+	}
+
+	if len(types) == 0 {
+		return "", nil
+	}
+	return types[0], content[types[0]]
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// mediaSample returns the media example, the first of its examples or a sample generated from its schema
+func (s *Spec) mediaSample(media *MediaType) (interface{}, error) {
+	if media.Example != nil {
+		return media.Example, nil
+	}
+
+	if len(media.Examples) > 0 {
+		names := make([]string, 0, len(media.Examples))
+		for name := range media.Examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		example, err := s.ResolveExample(media.Examples[names[0]])
+		if err != nil {
+			return nil, err
+		}
+		return example.Value, nil
+	}
+
+	return s.Sample(media.Schema)
+}
+
+func encodeBody(contentType string, body interface{}) (string, error) {
+	if str, ok := body.(string); ok && !isJSON(contentType) {
+		return str, nil
+	}
+
+	b, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error encoding example: %w", err)
+	}
+
+	return string(b), nil
+}
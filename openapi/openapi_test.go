@@ -0,0 +1,133 @@
+package openapi_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+	"github.com/rodrigo-kayala/mirage-mocker/openapi"
+)
+
+func Test_PathPattern(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("^/users$", openapi.PathPattern("", "/users"))
+	assert.Equal("^/v1/users/(?P<id>[^/]+)$", openapi.PathPattern("/v1/", "/users/{id}"))
+	assert.Equal(`^/files/(?P<file_id>[^/]+)\.json$`, openapi.PathPattern("", "/files/{file-id}.json"))
+}
+
+func Test_Parse(t *testing.T) {
+	_, err := openapi.Parse([]byte("swagger: \"2.0\"\n"))
+	assert.EqualError(t, err, `unsupported openapi version "", expected 3.x`)
+}
+
+func Test_Spec_Services(t *testing.T) {
+	assert := assert.New(t)
+
+	spec, err := openapi.Load("testdata/petstore.yml")
+	assert.NoError(err)
+	assert.Equal("/v1", spec.ServersBasePath())
+
+	services, err := spec.Services(config.Parser{Name: "pets", Log: true}, spec.ServersBasePath())
+	assert.NoError(err)
+
+	type want struct {
+		name    string
+		pattern string
+		method  string
+		status  int
+		body    string
+	}
+
+	wants := []want{
+		{"pets.listPets", "^/v1/pets$", "GET", 200, `[{"birth": "2021-01-01", "id": 1, "name": "Fido", "tag": "dog"}]`},
+		{"pets.createPet", "^/v1/pets$", "POST", 201, `{"id": 10, "name": "Rex"}`},
+		{"pets.myPets", "^/v1/pets/mine$", "GET", 200, "no pets"},
+		{"pets.showPetById", "^/v1/pets/(?P<petId>[^/]+)$", "GET", 200, `{"birth": "2021-01-01", "id": 1, "name": "Fido", "tag": "dog"}`},
+		{"pets.deletePet", "^/v1/pets/(?P<petId>[^/]+)$", "DELETE", 204, ""},
+	}
+
+	if !assert.Len(services, len(wants)) {
+		return
+	}
+
+	for i, w := range wants {
+		p := services[i].Parser
+		assert.Equal(w.name, p.Name)
+		assert.Equal(w.pattern, p.Pattern)
+		assert.Equal([]string{w.method}, p.Methods)
+		assert.Equal(w.status, p.Response.Status[w.method])
+		assert.True(p.Log)
+
+		switch {
+		case w.body == "":
+			assert.Empty(p.Response.Body)
+		case p.Response.Headers["content-type"] == "application/json":
+			assert.JSONEq(w.body, p.Response.Body)
+		default:
+			assert.Equal(w.body, p.Response.Body)
+		}
+	}
+
+	assert.Empty(config.Validate(config.Config{Services: services}))
+}
+
+func Test_Validator_Validate(t *testing.T) {
+	spec, err := openapi.Load("testdata/petstore.yml")
+	assert.NoError(t, err)
+
+	v, err := openapi.NewValidator(spec, "/v1")
+	assert.NoError(t, err)
+
+	type test struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		headers     map[string]string
+		body        string
+		want        []string
+	}
+
+	tests := []test{
+		{name: "valid list", method: "GET", target: "/v1/pets?limit=10", headers: map[string]string{"X-Tenant": "acme"}},
+		{name: "query above maximum", method: "GET", target: "/v1/pets?limit=200", want: []string{"query limit: must be <= 100"}},
+		{name: "query not a number", method: "GET", target: "/v1/pets?limit=ten", want: []string{`query limit: "ten" is not a number`}},
+		{name: "short header", method: "GET", target: "/v1/pets", headers: map[string]string{"X-Tenant": "a"}, want: []string{"header X-Tenant: must have at least 3 characters"}},
+		{name: "path param not an integer", method: "GET", target: "/v1/pets/abc", want: []string{`path petId: "abc" is not a number`}},
+		{name: "unknown path", method: "GET", target: "/v1/owners", want: []string{"path: no path of the spec matches /v1/owners"}},
+		{name: "unknown method", method: "PATCH", target: "/v1/pets/1", want: []string{"method: no PATCH operation for /pets/{petId}"}},
+		{name: "valid body", method: "POST", target: "/v1/pets", contentType: "application/json; charset=utf-8", body: `{"name": "Rex", "tag": "dog"}`},
+		{name: "missing body", method: "POST", target: "/v1/pets", want: []string{"body: is required"}},
+		{
+			name:        "invalid body",
+			method:      "POST",
+			target:      "/v1/pets",
+			contentType: "application/json",
+			body:        `{"tag": "bird"}`,
+			want:        []string{"body: $.name: is required", "body: $.tag: must be one of [dog cat]"},
+		},
+		{name: "bad content type", method: "POST", target: "/v1/pets", contentType: "text/plain", body: "Rex", want: []string{`body: unsupported content type "text/plain"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("content-type", tt.contentType)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			var got []string
+			for _, violation := range v.Validate(req, []byte(tt.body)) {
+				got = append(got, violation.String())
+			}
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
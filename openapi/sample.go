@@ -0,0 +1,148 @@
+package openapi
+
+import (
+	"sort"
+)
+
+// maxSampleDepth limits the sample generation of recursive schemas
+const maxSampleDepth = 8
+
+// sample formats returned for string schemas
+var stringFormats = map[string]string{
+	"date":      "2021-01-01",
+	"date-time": "2021-01-01T00:00:00Z",
+	"time":      "00:00:00",
+	"email":     "user@example.com",
+	"uuid":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"byte":      "c3RyaW5n",
+	"password":  "password",
+}
+
+// Sample generates a sample value for a schema, using its example, default or enum values when present
+func (s *Spec) Sample(schema *Schema) (interface{}, error) {
+	return s.sample(schema, 0)
+}
+
+func (s *Spec) sample(schema *Schema, depth int) (interface{}, error) {
+	schema, err := s.ResolveSchema(schema)
+	if err != nil || schema == nil {
+		return nil, err
+	}
+
+	switch {
+	case schema.Example != nil:
+		return schema.Example, nil
+	case schema.Default != nil:
+		return schema.Default, nil
+	case len(schema.Enum) > 0:
+		return schema.Enum[0], nil
+	case depth > maxSampleDepth:
+		return nil, nil
+	}
+
+	if len(schema.AllOf) > 0 {
+		merged := map[string]interface{}{}
+		for _, sub := range schema.AllOf {
+			v, err := s.sample(sub, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if m, ok := v.(map[string]interface{}); ok {
+				for k, v := range m {
+					merged[k] = v
+				}
+			}
+		}
+		return merged, nil
+	}
+
+	if len(schema.OneOf) > 0 {
+		return s.sample(schema.OneOf[0], depth+1)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		return s.sample(schema.AnyOf[0], depth+1)
+	}
+
+	switch schemaType(schema) {
+	case "object":
+		out := map[string]interface{}{}
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			v, err := s.sample(schema.Properties[name], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = v
+		}
+		return out, nil
+	case "array":
+		item, err := s.sample(schema.Items, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		n := 1
+		if schema.MinItems != nil && *schema.MinItems > n {
+			n = *schema.MinItems
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			out[i] = item
+		}
+		return out, nil
+	case "integer":
+		if schema.Minimum != nil {
+			return int64(*schema.Minimum), nil
+		}
+		return 0, nil
+	case "number":
+		if schema.Minimum != nil {
+			return *schema.Minimum, nil
+		}
+		return 0.0, nil
+	case "boolean":
+		return true, nil
+	case "string":
+		if v, ok := stringFormats[schema.Format]; ok {
+			return v, nil
+		}
+
+		v := "string"
+		if schema.MinLength != nil {
+			for len(v) < *schema.MinLength {
+				v += "string"
+			}
+		}
+		if schema.MaxLength != nil && len(v) > *schema.MaxLength {
+			v = v[:*schema.MaxLength]
+		}
+		return v, nil
+	default:
+		return nil, nil
+	}
+}
+
+// schemaType returns the schema type, inferring it from the other attributes when not set
+func schemaType(schema *Schema) string {
+	switch {
+	case schema.Type != "":
+		return schema.Type
+	case schema.Properties != nil || schema.AdditionalProperties != nil:
+		return "object"
+	case schema.Items != nil:
+		return "array"
+	default:
+		return ""
+	}
+}
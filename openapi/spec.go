@@ -0,0 +1,266 @@
+package openapi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the subset of an OpenAPI 3 document used by mirage mocker
+type Spec struct {
+	OpenAPI    string               `yaml:"openapi"`
+	Servers    []Server             `yaml:"servers"`
+	Paths      map[string]*PathItem `yaml:"paths"`
+	Components Components           `yaml:"components"`
+}
+
+// Server structure
+type Server struct {
+	URL string `yaml:"url"`
+}
+
+// Components structure
+type Components struct {
+	Schemas       map[string]*Schema      `yaml:"schemas"`
+	Parameters    map[string]*Parameter   `yaml:"parameters"`
+	Responses     map[string]*Response    `yaml:"responses"`
+	RequestBodies map[string]*RequestBody `yaml:"requestBodies"`
+	Examples      map[string]*Example     `yaml:"examples"`
+}
+
+// PathItem structure
+type PathItem struct {
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *Operation   `yaml:"get"`
+	Put        *Operation   `yaml:"put"`
+	Post       *Operation   `yaml:"post"`
+	Delete     *Operation   `yaml:"delete"`
+	Options    *Operation   `yaml:"options"`
+	Head       *Operation   `yaml:"head"`
+	Patch      *Operation   `yaml:"patch"`
+	Trace      *Operation   `yaml:"trace"`
+}
+
+// Operations returns the operations of the path by HTTP method
+func (p *PathItem) Operations() map[string]*Operation {
+	ops := map[string]*Operation{
+		"GET":     p.Get,
+		"PUT":     p.Put,
+		"POST":    p.Post,
+		"DELETE":  p.Delete,
+		"OPTIONS": p.Options,
+		"HEAD":    p.Head,
+		"PATCH":   p.Patch,
+		"TRACE":   p.Trace,
+	}
+
+	for method, op := range ops {
+		if op == nil {
+			delete(ops, method)
+		}
+	}
+
+	return ops
+}
+
+// Operation structure
+type Operation struct {
+	OperationID string               `yaml:"operationId"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+// Parameter structure
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+// RequestBody structure
+type RequestBody struct {
+	Ref      string                `yaml:"$ref"`
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+// Response structure
+type Response struct {
+	Ref     string                `yaml:"$ref"`
+	Content map[string]*MediaType `yaml:"content"`
+}
+
+// MediaType structure
+type MediaType struct {
+	Schema   *Schema             `yaml:"schema"`
+	Example  interface{}         `yaml:"example"`
+	Examples map[string]*Example `yaml:"examples"`
+}
+
+// Example structure
+type Example struct {
+	Ref   string      `yaml:"$ref"`
+	Value interface{} `yaml:"value"`
+}
+
+// Schema structure
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 string             `yaml:"type"`
+	Format               string             `yaml:"format"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	AdditionalProperties interface{}        `yaml:"additionalProperties"`
+	Items                *Schema            `yaml:"items"`
+	Required             []string           `yaml:"required"`
+	Enum                 []interface{}      `yaml:"enum"`
+	Example              interface{}        `yaml:"example"`
+	Default              interface{}        `yaml:"default"`
+	AllOf                []*Schema          `yaml:"allOf"`
+	OneOf                []*Schema          `yaml:"oneOf"`
+	AnyOf                []*Schema          `yaml:"anyOf"`
+	Nullable             bool               `yaml:"nullable"`
+	Minimum              *float64           `yaml:"minimum"`
+	Maximum              *float64           `yaml:"maximum"`
+	MinLength            *int               `yaml:"minLength"`
+	MaxLength            *int               `yaml:"maxLength"`
+	Pattern              string             `yaml:"pattern"`
+	MinItems             *int               `yaml:"minItems"`
+	MaxItems             *int               `yaml:"maxItems"`
+}
+
+// Load reads an OpenAPI 3 document, in YAML or JSON
+func Load(path string) (*Spec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading openapi spec: %w", err)
+	}
+
+	return Parse(b)
+}
+
+// Parse parses an OpenAPI 3 document, in YAML or JSON
+func Parse(b []byte) (*Spec, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(b, &spec); err != nil {
+		return nil, fmt.Errorf("error parsing openapi spec: %w", err)
+	}
+
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported openapi version %q, expected 3.x", spec.OpenAPI)
+	}
+
+	return &spec, nil
+}
+
+// refName returns the component name of a local reference like #/components/schemas/Name
+func refName(ref string, kind string) (string, error) {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("unsupported reference %s", ref)
+	}
+
+	return strings.TrimPrefix(ref, prefix), nil
+}
+
+// ResolveSchema follows schema references
+func (s *Spec) ResolveSchema(schema *Schema) (*Schema, error) {
+	for seen := 0; schema != nil && schema.Ref != ""; seen++ {
+		if seen > 32 {
+			return nil, fmt.Errorf("reference loop at %s", schema.Ref)
+		}
+
+		name, err := refName(schema.Ref, "schemas")
+		if err != nil {
+			return nil, err
+		}
+
+		next, ok := s.Components.Schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("schema %s not found", schema.Ref)
+		}
+		schema = next
+	}
+
+	return schema, nil
+}
+
+// ResolveParameter follows a parameter reference
+func (s *Spec) ResolveParameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+
+	name, err := refName(p.Ref, "parameters")
+	if err != nil {
+		return nil, err
+	}
+
+	out, ok := s.Components.Parameters[name]
+	if !ok {
+		return nil, fmt.Errorf("parameter %s not found", p.Ref)
+	}
+
+	return out, nil
+}
+
+// ResolveResponse follows a response reference
+func (s *Spec) ResolveResponse(r *Response) (*Response, error) {
+	if r.Ref == "" {
+		return r, nil
+	}
+
+	name, err := refName(r.Ref, "responses")
+	if err != nil {
+		return nil, err
+	}
+
+	out, ok := s.Components.Responses[name]
+	if !ok {
+		return nil, fmt.Errorf("response %s not found", r.Ref)
+	}
+
+	return out, nil
+}
+
+// ResolveRequestBody follows a request body reference
+func (s *Spec) ResolveRequestBody(rb *RequestBody) (*RequestBody, error) {
+	if rb.Ref == "" {
+		return rb, nil
+	}
+
+	name, err := refName(rb.Ref, "requestBodies")
+	if err != nil {
+		return nil, err
+	}
+
+	out, ok := s.Components.RequestBodies[name]
+	if !ok {
+		return nil, fmt.Errorf("request body %s not found", rb.Ref)
+	}
+
+	return out, nil
+}
+
+// ResolveExample follows an example reference
+func (s *Spec) ResolveExample(e *Example) (*Example, error) {
+	if e.Ref == "" {
+		return e, nil
+	}
+
+	name, err := refName(e.Ref, "examples")
+	if err != nil {
+		return nil, err
+	}
+
+	out, ok := s.Components.Examples[name]
+	if !ok {
+		return nil, fmt.Errorf("example %s not found", e.Ref)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,416 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation is a difference between a request and the operation of the spec it targets
+type Violation struct {
+	In      string `json:"in"`
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Name == "" {
+		return fmt.Sprintf("%s: %s", v.In, v.Message)
+	}
+
+	return fmt.Sprintf("%s %s: %s", v.In, v.Name, v.Message)
+}
+
+// Validator validates requests against the operations of a spec
+type Validator struct {
+	spec   *Spec
+	routes []route
+}
+
+type route struct {
+	pattern    *regexp.Regexp
+	path       string
+	operations map[string]*Operation
+	parameters []*Parameter
+}
+
+// NewValidator creates a validator for the operations of a spec, served under basePath
+func NewValidator(spec *Spec, basePath string) (*Validator, error) {
+	v := &Validator{spec: spec}
+	for _, path := range spec.SortedPaths() {
+		pattern, err := regexp.Compile(PathPattern(basePath, path))
+		if err != nil {
+			return nil, fmt.Errorf("error compiling path %s: %w", path, err)
+		}
+
+		item := spec.Paths[path]
+		v.routes = append(v.routes, route{
+			pattern:    pattern,
+			path:       path,
+			operations: item.Operations(),
+			parameters: item.Parameters,
+		})
+	}
+
+	return v, nil
+}
+
+// Validate returns the violations of a request, whose body was already read
+func (v *Validator) Validate(r *http.Request, body []byte) []Violation {
+	var rt *route
+	var params []string
+	for i := range v.routes {
+		if params = v.routes[i].pattern.FindStringSubmatch(r.URL.Path); params != nil {
+			rt = &v.routes[i]
+			break
+		}
+	}
+
+	if rt == nil {
+		return []Violation{{In: "path", Message: fmt.Sprintf("no path of the spec matches %s", r.URL.Path)}}
+	}
+
+	op, ok := rt.operations[r.Method]
+	if !ok {
+		return []Violation{{In: "method", Message: fmt.Sprintf("no %s operation for %s", r.Method, rt.path)}}
+	}
+
+	pathValues := make(map[string]string)
+	for i, name := range rt.pattern.SubexpNames() {
+		if name != "" {
+			pathValues[name] = params[i]
+		}
+	}
+
+	var out []Violation
+	for _, p := range v.operationParameters(rt, op, &out) {
+		var values []string
+		switch p.In {
+		case "path":
+			if value, ok := pathValues[unsafeGroupChar.ReplaceAllString(p.Name, "_")]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = r.URL.Query()[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		case "cookie":
+			if c, err := r.Cookie(p.Name); err == nil {
+				values = []string{c.Value}
+			}
+		}
+
+		for _, msg := range v.spec.validateParameter(p, values) {
+			out = append(out, Violation{In: p.In, Name: p.Name, Message: msg})
+		}
+	}
+
+	if op.RequestBody != nil {
+		rb, err := v.spec.ResolveRequestBody(op.RequestBody)
+		if err != nil {
+			return append(out, Violation{In: "body", Message: err.Error()})
+		}
+		out = append(out, v.spec.validateBody(rb, r.Header.Get("content-type"), body)...)
+	}
+
+	return out
+}
+
+// operationParameters merges the path and the operation parameters, the latter overriding the former
+func (v *Validator) operationParameters(rt *route, op *Operation, out *[]Violation) []*Parameter {
+	var params []*Parameter
+	index := make(map[string]int)
+	for _, p := range append(append([]*Parameter{}, rt.parameters...), op.Parameters...) {
+		p, err := v.spec.ResolveParameter(p)
+		if err != nil {
+			*out = append(*out, Violation{In: "parameter", Message: err.Error()})
+			continue
+		}
+
+		key := p.In + "." + p.Name
+		if i, ok := index[key]; ok {
+			params[i] = p
+			continue
+		}
+		index[key] = len(params)
+		params = append(params, p)
+	}
+
+	return params
+}
+
+// ignoredHeaders are header parameters the spec says must be ignored
+var ignoredHeaders = map[string]bool{"Accept": true, "Content-Type": true, "Authorization": true}
+
+func (s *Spec) validateParameter(p *Parameter, values []string) []string {
+	if p.In == "header" && ignoredHeaders[http.CanonicalHeaderKey(p.Name)] {
+		return nil
+	}
+
+	if len(values) == 0 {
+		if p.Required || p.In == "path" {
+			return []string{"is required"}
+		}
+		return nil
+	}
+
+	schema, err := s.ResolveSchema(p.Schema)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if schema == nil {
+		return nil
+	}
+
+	var value interface{}
+	if schemaType(schema) == "array" {
+		var raw []string
+		for _, v := range values {
+			raw = append(raw, strings.Split(v, ",")...)
+		}
+
+		items, err := s.ResolveSchema(schema.Items)
+		if err != nil {
+			return []string{err.Error()}
+		}
+
+		list := make([]interface{}, len(raw))
+		for i, v := range raw {
+			if list[i], err = parseValue(items, v); err != nil {
+				return []string{err.Error()}
+			}
+		}
+		value = list
+	} else if value, err = parseValue(schema, values[0]); err != nil {
+		return []string{err.Error()}
+	}
+
+	return s.validateSchema(schema, value, "", 0)
+}
+
+// parseValue converts a parameter value to the schema type
+func parseValue(schema *Schema, v string) (interface{}, error) {
+	if schema == nil {
+		return v, nil
+	}
+
+	switch schemaType(schema) {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", v)
+		}
+		return b, nil
+	default:
+		return v, nil
+	}
+}
+
+func (s *Spec) validateBody(rb *RequestBody, contentType string, body []byte) []Violation {
+	if len(body) == 0 {
+		if rb.Required {
+			return []Violation{{In: "body", Message: "is required"}}
+		}
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+
+	media, ok := rb.Content[mediaType]
+	if !ok {
+		media, ok = rb.Content[strings.SplitN(mediaType, "/", 2)[0]+"/*"]
+	}
+	if !ok {
+		media, ok = rb.Content["*/*"]
+	}
+	if !ok {
+		return []Violation{{In: "body", Message: fmt.Sprintf("unsupported content type %q", contentType)}}
+	}
+
+	if media == nil || media.Schema == nil || !isJSON(mediaType) {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []Violation{{In: "body", Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+
+	var out []Violation
+	for _, msg := range s.validateSchema(media.Schema, value, "$", 0) {
+		out = append(out, Violation{In: "body", Message: msg})
+	}
+
+	return out
+}
+
+// validateSchema returns the violations of a value decoded from JSON, prefixed by their location
+func (s *Spec) validateSchema(schema *Schema, value interface{}, at string, depth int) []string {
+	schema, err := s.ResolveSchema(schema)
+	if err != nil {
+		return []string{located(at, err.Error())}
+	}
+	if schema == nil || depth > maxSampleDepth*4 {
+		return nil
+	}
+
+	if value == nil {
+		if schema.Nullable || schemaType(schema) == "" {
+			return nil
+		}
+		return []string{located(at, "must not be null")}
+	}
+
+	var out []string
+	for _, sub := range schema.AllOf {
+		out = append(out, s.validateSchema(sub, value, at, depth+1)...)
+	}
+
+	if len(schema.OneOf) > 0 {
+		matches := 0
+		for _, sub := range schema.OneOf {
+			if len(s.validateSchema(sub, value, at, depth+1)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			out = append(out, located(at, fmt.Sprintf("must match exactly one schema of oneOf, matches %d", matches)))
+		}
+	}
+
+	if len(schema.AnyOf) > 0 {
+		matched := false
+		for _, sub := range schema.AnyOf {
+			if len(s.validateSchema(sub, value, at, depth+1)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, located(at, "must match at least one schema of anyOf"))
+		}
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		out = append(out, located(at, fmt.Sprintf("must be one of %v", schema.Enum)))
+	}
+
+	switch schemaType(schema) {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(out, located(at, "must be an object"))
+		}
+
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				out = append(out, located(at+"."+name, "is required"))
+			}
+		}
+
+		for name, v := range obj {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				if additional, isBool := schema.AdditionalProperties.(bool); isBool && !additional {
+					out = append(out, located(at+"."+name, "is not allowed"))
+				}
+				continue
+			}
+			out = append(out, s.validateSchema(prop, v, at+"."+name, depth+1)...)
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return append(out, located(at, "must be an array"))
+		}
+
+		if schema.MinItems != nil && len(list) < *schema.MinItems {
+			out = append(out, located(at, fmt.Sprintf("must have at least %d items", *schema.MinItems)))
+		}
+		if schema.MaxItems != nil && len(list) > *schema.MaxItems {
+			out = append(out, located(at, fmt.Sprintf("must have at most %d items", *schema.MaxItems)))
+		}
+
+		for i, v := range list {
+			out = append(out, s.validateSchema(schema.Items, v, fmt.Sprintf("%s[%d]", at, i), depth+1)...)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return append(out, located(at, "must be a number"))
+		}
+
+		if schemaType(schema) == "integer" && n != float64(int64(n)) {
+			out = append(out, located(at, "must be an integer"))
+		}
+		if schema.Minimum != nil && n < *schema.Minimum {
+			out = append(out, located(at, fmt.Sprintf("must be >= %v", *schema.Minimum)))
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			out = append(out, located(at, fmt.Sprintf("must be <= %v", *schema.Maximum)))
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return append(out, located(at, "must be a string"))
+		}
+
+		length := utf8.RuneCountInString(str)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			out = append(out, located(at, fmt.Sprintf("must have at least %d characters", *schema.MinLength)))
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			out = append(out, located(at, fmt.Sprintf("must have at most %d characters", *schema.MaxLength)))
+		}
+		if schema.Pattern != "" {
+			if re, err := regexp.Compile(schema.Pattern); err == nil && !re.MatchString(str) {
+				out = append(out, located(at, fmt.Sprintf("must match %s", schema.Pattern)))
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			out = append(out, located(at, "must be a boolean"))
+		}
+	}
+
+	return out
+}
+
+func located(at string, msg string) string {
+	if at == "" {
+		return msg
+	}
+
+	return at + ": " + msg
+}
+
+// inEnum compares the value with the enum values by their JSON encoding, since numbers may be
+// decoded to different types
+func inEnum(enum []interface{}, value interface{}) bool {
+	v, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+
+	for _, e := range enum {
+		if b, err := json.Marshal(e); err == nil && string(b) == string(v) {
+			return true
+		}
+	}
+
+	return false
+}
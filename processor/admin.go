@@ -0,0 +1,252 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// AdminPrefix is the path prefix reserved for the admin API
+const AdminPrefix = "/__admin"
+
+// Admin returns the admin API handler
+func (rp *processor) Admin() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminPrefix+"/scenarios", rp.adminScenarios)
+	mux.HandleFunc(AdminPrefix+"/scenarios/", rp.adminScenario)
+	mux.HandleFunc(AdminPrefix+"/parsers", rp.adminParsers)
+	mux.HandleFunc(AdminPrefix+"/parsers/", rp.adminParser)
+	mux.HandleFunc(AdminPrefix+"/requests", rp.adminRequests)
+	mux.HandleFunc(AdminPrefix+"/requests/count", rp.adminRequestsCount)
+	mux.HandleFunc(AdminPrefix+"/reset", rp.adminReset)
+	mux.HandleFunc(AdminPrefix+"/shutdown", rp.adminShutdown)
+
+	return mux
+}
+
+type parserEntry struct {
+	Index  int           `json:"index"`
+	Parser config.Parser `json:"parser"`
+}
+
+// adminParsers lists (GET) or adds (POST) parsers. New parsers are appended, unless an index is given
+func (rp *processor) adminParsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		parsers := rp.parsers()
+		entries := make([]parserEntry, 0, len(parsers))
+		for i, p := range parsers {
+			entries = append(entries, parserEntry{Index: i, Parser: p.GetBaseParser().Config})
+		}
+		writeJSON(w, entries, http.StatusOK)
+	case http.MethodPost:
+		p, err := rp.readParser(r)
+		if err != nil {
+			errorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		index := -1
+		if v := r.URL.Query().Get("index"); v != "" {
+			if index, err = strconv.Atoi(v); err != nil {
+				errorResponse(w, fmt.Sprintf("bad index %s", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		index, err = rp.insertParser(index, p)
+		if err != nil {
+			stopRemoved([]parser{p}, nil)
+			errorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, parserEntry{Index: index, Parser: p.GetBaseParser().Config}, http.StatusCreated)
+	default:
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// adminParser gets (GET), replaces (PUT) or deletes (DELETE) a parser identified by its name or index
+func (rp *processor) adminParser(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, AdminPrefix+"/parsers/")
+
+	switch r.Method {
+	case http.MethodGet:
+		index, p, ok := rp.parserByID(id)
+		if !ok {
+			errorResponse(w, fmt.Sprintf("parser %s not found", id), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, parserEntry{Index: index, Parser: p.GetBaseParser().Config}, http.StatusOK)
+	case http.MethodPut:
+		p, err := rp.readParser(r)
+		if err != nil {
+			errorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the parser is looked up again under the lock, since others may have changed meanwhile
+		index, err := rp.replaceParserByID(id, p)
+		if err != nil {
+			stopRemoved([]parser{p}, nil)
+			errorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, parserEntry{Index: index, Parser: p.GetBaseParser().Config}, http.StatusOK)
+	case http.MethodDelete:
+		if err := rp.deleteParserByID(id); err != nil {
+			errorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// readParser creates a parser from a request body with the parser config, in YAML or JSON
+func (rp *processor) readParser(r *http.Request) (parser, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read body: %w", err)
+	}
+
+	var conf config.Parser
+	if err := yaml.UnmarshalStrict(b, &conf); err != nil {
+		return nil, fmt.Errorf("error parsing parser config: %w", err)
+	}
+
+	if conf.ConfigType == "openapi" {
+		return nil, fmt.Errorf("openapi services can't be added at runtime, import them with the import command")
+	}
+
+	if errs := config.Validate(config.Config{Services: []config.Service{{Parser: conf}}}); len(errs) > 0 {
+		return nil, errs
+	}
+
+	return rp.createParser(conf)
+}
+
+// adminReset resets the runtime state
+func (rp *processor) adminReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	rp.scenarios.reset()
+	rp.journal.clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminRequests lists (GET) the journal requests matching the filter in the query parameters,
+// or clears the journal (DELETE)
+func (rp *processor) adminRequests(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f, err := parseJournalFilter(r.URL.Query())
+		if err != nil {
+			errorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, rp.journal.find(f), http.StatusOK)
+	case http.MethodDelete:
+		rp.journal.clear()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+type requestsCount struct {
+	Count int `json:"count"`
+}
+
+// adminRequestsCount counts the journal requests matching the filter in the query parameters
+func (rp *processor) adminRequestsCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, err := parseJournalFilter(r.URL.Query())
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, requestsCount{Count: len(rp.journal.find(f))}, http.StatusOK)
+}
+
+// adminShutdown requests the server shutdown
+func (rp *processor) adminShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	rp.closeOnce.Do(func() { close(rp.done) })
+}
+
+// adminScenarios lists (GET) or resets (DELETE) all scenarios
+func (rp *processor) adminScenarios(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, rp.scenarios.all(), http.StatusOK)
+	case http.MethodDelete:
+		rp.scenarios.reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+type scenarioState struct {
+	State string `json:"state"`
+}
+
+// adminScenario gets (GET), sets (PUT) or resets (DELETE) a single scenario
+func (rp *processor) adminScenario(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, AdminPrefix+"/scenarios/")
+	if name == "" {
+		errorResponse(w, "missing scenario name", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, scenarioState{State: rp.scenarios.state(name)}, http.StatusOK)
+	case http.MethodPut:
+		var s scenarioState
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil || s.State == "" {
+			errorResponse(w, fmt.Sprintf("bad scenario state: %v", err), http.StatusBadRequest)
+			return
+		}
+		rp.scenarios.transition(name, s.State)
+		writeJSON(w, s, http.StatusOK)
+	case http.MethodDelete:
+		rp.scenarios.reset(name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		errorResponse(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("error encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
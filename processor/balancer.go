@@ -0,0 +1,245 @@
+package processor
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+const (
+	// DefaultEjectDuration is the time an upstream is not used after being ejected
+	DefaultEjectDuration = 30 * time.Second
+	// DefaultHealthCheckInterval is the interval between active health checks
+	DefaultHealthCheckInterval = 10 * time.Second
+	// DefaultHealthCheckTimeout is the time an upstream has to answer a health check
+	DefaultHealthCheckTimeout = 2 * time.Second
+)
+
+// upstream is one of the targets of a balancer
+type upstream struct {
+	url    *url.URL
+	weight int
+
+	// guarded by the balancer lock
+	failures     int
+	ejectedUntil time.Time
+	unhealthy    bool
+	current      int
+}
+
+type upstreamKey struct{}
+
+// balancer distributes the requests of a pass parser between upstreams. Upstreams failing consecutive
+// requests are ejected for a while, and health checks (started with the first request) mark upstreams
+// as unhealthy. When no upstream is available, all of them are used
+type balancer struct {
+	upstreams    []*upstream
+	strategy     string
+	stickyHeader string
+	next         uint64
+	rand         *random
+
+	ejectFailures int
+	ejectDuration time.Duration
+
+	healthPath     string
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	healthOnce     sync.Once
+	done           chan struct{}
+	stopOnce       sync.Once
+
+	mu sync.Mutex
+}
+
+func newBalancer(conf config.Parser, rnd *random) (*balancer, error) {
+	lb := conf.LoadBalancing
+	b := &balancer{
+		rand:           rnd,
+		strategy:       lb.Strategy,
+		stickyHeader:   lb.StickyHeader,
+		ejectFailures:  lb.Eject.Failures,
+		ejectDuration:  DefaultEjectDuration,
+		healthPath:     lb.HealthCheck.Path,
+		healthInterval: DefaultHealthCheckInterval,
+		healthTimeout:  DefaultHealthCheckTimeout,
+		done:           make(chan struct{}),
+	}
+
+	if b.strategy == "" {
+		b.strategy = "round-robin"
+	}
+
+	for _, u := range conf.PassBaseURIs {
+		target, err := url.Parse(u.URI)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing pass url %s: %w", u.URI, err)
+		}
+
+		weight := u.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		b.upstreams = append(b.upstreams, &upstream{url: target, weight: weight})
+	}
+
+	if err := parseDuration("eject duration", lb.Eject.Duration, &b.ejectDuration); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("health check interval", lb.HealthCheck.Interval, &b.healthInterval); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("health check timeout", lb.HealthCheck.Timeout, &b.healthTimeout); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// pick chooses the upstream for a request
+func (b *balancer) pick(r *http.Request) *upstream {
+	if b.healthPath != "" {
+		b.healthOnce.Do(func() { go b.healthCheck() })
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	available := make([]*upstream, 0, len(b.upstreams))
+	for _, u := range b.upstreams {
+		if !u.unhealthy && now.After(u.ejectedUntil) {
+			available = append(available, u)
+		}
+	}
+	if len(available) == 0 {
+		log.Warn().Msg("no upstream available, using all of them")
+		available = b.upstreams
+	}
+
+	switch b.strategy {
+	case "random":
+		return available[b.rand.Intn(len(available))]
+	case "weighted":
+		return pickWeighted(available)
+	case "sticky":
+		if key := r.Header.Get(b.stickyHeader); key != "" {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(key))
+			return available[h.Sum32()%uint32(len(available))]
+		}
+	}
+
+	b.next++
+	return available[(b.next-1)%uint64(len(available))]
+}
+
+// pickWeighted is the smooth weighted round-robin: every upstream gains its weight, and the one with
+// the highest total is chosen and loses the sum of the weights. Callers must hold the lock
+func pickWeighted(available []*upstream) *upstream {
+	var best *upstream
+	total := 0
+	for _, u := range available {
+		u.current += u.weight
+		total += u.weight
+		if best == nil || u.current > best.current {
+			best = u
+		}
+	}
+	best.current -= total
+
+	return best
+}
+
+// report records the result of a request to an upstream, ejecting it after consecutive failures
+func (b *balancer) report(u *upstream, ok bool) {
+	if b.ejectFailures <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		u.failures = 0
+		return
+	}
+
+	u.failures++
+	if u.failures >= b.ejectFailures {
+		log.Warn().Msgf("upstream %s failed %d times, ejected for %v", u.url, u.failures, b.ejectDuration)
+		u.failures = 0
+		u.ejectedUntil = time.Now().Add(b.ejectDuration)
+	}
+}
+
+// healthCheck checks every upstream on each interval, until the balancer is stopped
+func (b *balancer) healthCheck() {
+	client := http.Client{Timeout: b.healthTimeout}
+	ticker := time.NewTicker(b.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, u := range b.upstreams {
+			healthy := b.checkUpstream(&client, u)
+
+			b.mu.Lock()
+			if healthy == u.unhealthy {
+				log.Info().Msgf("upstream %s health changed, healthy: %t", u.url, healthy)
+			}
+			u.unhealthy = !healthy
+			b.mu.Unlock()
+		}
+
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *balancer) checkUpstream(client *http.Client, u *upstream) bool {
+	target := *u.url
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(b.healthPath, "/")
+
+	resp, err := client.Get(target.String())
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode < 400
+}
+
+// stop stops the health checks
+func (b *balancer) stop() {
+	b.stopOnce.Do(func() { close(b.done) })
+	// health checks must not start after the balancer is stopped
+	b.healthOnce.Do(func() {})
+}
+
+// balancerTransport reports the result of each request to the balancer. Connection errors and 5xx
+// statuses are failures
+type balancerTransport struct {
+	balancer *balancer
+	next     http.RoundTripper
+}
+
+func (t *balancerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(r)
+
+	if u, ok := r.Context().Value(upstreamKey{}).(*upstream); ok {
+		t.balancer.report(u, err == nil && resp.StatusCode < 500)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// normal quantiles of the percentiles used to fit the lognormal distribution
+const (
+	z95 = 1.6448536269514722
+	z99 = 2.3263478740408408
+)
+
+// latency draws the delays of a parser from a distribution. Delays are bounded by min and max, when set
+type latency struct {
+	distribution string
+	min          time.Duration
+	max          time.Duration
+	value        time.Duration
+	mean         float64
+	stddev       float64
+	alpha        float64
+	// mu and sigma are the parameters of the lognormal distribution, in log nanoseconds
+	mu    float64
+	sigma float64
+	// after delays pass responses after the upstream answers, instead of before the request is sent
+	after bool
+}
+
+// newLatency returns nil when no delay is configured
+func newLatency(conf config.Delay) (*latency, error) {
+	l := &latency{distribution: conf.Distribution, alpha: conf.Alpha, after: conf.After}
+	if l.distribution == "" {
+		// without a distribution, the delay is only set when both min and max are
+		if conf.Min == "" || conf.Max == "" {
+			return nil, nil
+		}
+		l.distribution = "uniform"
+	}
+
+	var mean, stddev, p50, p95, p99 time.Duration
+	if err := parseDuration("min delay", conf.Min, &l.min); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("max delay", conf.Max, &l.max); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("value delay", conf.Value, &l.value); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("mean delay", conf.Mean, &mean); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("stddev delay", conf.StdDev, &stddev); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("p50 delay", conf.P50, &p50); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("p95 delay", conf.P95, &p95); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("p99 delay", conf.P99, &p99); err != nil {
+		return nil, err
+	}
+
+	l.mean = float64(mean)
+	l.stddev = float64(stddev)
+
+	switch l.distribution {
+	case "lognormal":
+		if mean <= 0 {
+			return nil, fmt.Errorf("the mean of the lognormal delay must be greater than 0")
+		}
+		// parameters giving the configured mean and standard deviation
+		l.sigma = math.Sqrt(math.Log(1 + l.stddev*l.stddev/(l.mean*l.mean)))
+		l.mu = math.Log(l.mean) - l.sigma*l.sigma/2
+	case "percentiles":
+		if err := l.fitPercentiles(p50, p95, p99); err != nil {
+			return nil, err
+		}
+	case "pareto":
+		if l.min <= 0 || l.alpha <= 0 {
+			return nil, fmt.Errorf("the min and alpha of the pareto delay must be greater than 0")
+		}
+	}
+
+	return l, nil
+}
+
+// fitPercentiles fits a lognormal distribution to the percentiles: the median sets mu, and sigma is the
+// average of the ones matching each of the other percentiles
+func (l *latency) fitPercentiles(p50 time.Duration, p95 time.Duration, p99 time.Duration) error {
+	if p50 <= 0 {
+		return fmt.Errorf("the p50 delay must be greater than 0")
+	}
+	l.mu = math.Log(float64(p50))
+
+	var sigmas []float64
+	for _, p := range []struct {
+		value time.Duration
+		z     float64
+	}{{p95, z95}, {p99, z99}} {
+		if p.value == 0 {
+			continue
+		}
+		if p.value < p50 {
+			return fmt.Errorf("the p95 and p99 delays can't be lower than p50")
+		}
+		sigmas = append(sigmas, (math.Log(float64(p.value))-l.mu)/p.z)
+	}
+	if len(sigmas) == 0 {
+		return fmt.Errorf("p95 or p99 is required for the percentiles delay")
+	}
+
+	for _, s := range sigmas {
+		l.sigma += s / float64(len(sigmas))
+	}
+
+	return nil
+}
+
+// sample draws a delay
+func (l *latency) sample(rnd *random) time.Duration {
+	var d float64
+	switch l.distribution {
+	case "fixed":
+		return l.value
+	case "uniform":
+		delta := int64(l.max - l.min)
+		if delta <= 0 {
+			return l.min
+		}
+		return time.Duration(rnd.Int63n(delta)) + l.min
+	case "normal":
+		d = l.mean + l.stddev*rnd.NormFloat64()
+	case "lognormal", "percentiles":
+		d = math.Exp(l.mu + l.sigma*rnd.NormFloat64())
+	case "pareto":
+		// 1 - Float64 is in (0, 1], avoiding a division by zero
+		d = float64(l.min) / math.Pow(1-rnd.Float64(), 1/l.alpha)
+	}
+
+	if d < float64(l.min) {
+		d = float64(l.min)
+	}
+	if l.max > 0 && d > float64(l.max) {
+		d = float64(l.max)
+	}
+	if d > math.MaxInt64 {
+		d = math.MaxInt64
+	}
+
+	return time.Duration(d)
+}
+
+// wait sleeps for a delay drawn from the distribution
+func (l *latency) wait(rnd *random) {
+	if d := l.sample(rnd); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// delayTransport delays the upstream responses of pass parsers, after the upstream answers
+type delayTransport struct {
+	latency *latency
+	rand    *random
+	next    http.RoundTripper
+}
+
+func (t *delayTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(r)
+	if err == nil {
+		t.latency.wait(t.rand)
+	}
+
+	return resp, err
+}
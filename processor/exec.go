@@ -0,0 +1,375 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// DefaultExecTimeout is the time an exec plugin has to answer when no timeout is configured
+const DefaultExecTimeout = 10 * time.Second
+
+// execRequest is the JSON message describing a request, sent to exec plugins. Transform plugins
+// answer with the same message, with the fields to change
+type execRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   url.Values        `json:"query"`
+	Headers http.Header       `json:"headers"`
+	Vars    map[string]string `json:"vars,omitempty"`
+	Body    string            `json:"body"`
+	Status  int               `json:"status,omitempty"`
+}
+
+// execResponse is the JSON message with the response built by exec plugins
+type execResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// messagePlugin exchanges the JSON messages of exec plugins: execRequest in, and execResponse or the
+// changed execRequest out. It is implemented by exec and WASM plugins
+type messagePlugin interface {
+	stopper
+	call(in interface{}, out interface{}) error
+}
+
+// execPlugin is an external program (or HTTP endpoint) receiving a JSON message and answering another.
+// Commands are run once per message, unless worker mode is set, where a single process is kept running
+// and exchanges one message per line
+type execPlugin struct {
+	command []string
+	url     string
+	timeout time.Duration
+	worker  *execWorker
+}
+
+func newExecPlugin(conf config.Exec) (*execPlugin, error) {
+	if len(conf.Command) == 0 && conf.URL == "" {
+		return nil, errors.New("exec command or url is required")
+	}
+
+	ep := &execPlugin{
+		command: conf.Command,
+		url:     conf.URL,
+		timeout: DefaultExecTimeout,
+	}
+
+	if err := parseDuration("exec timeout", conf.Timeout, &ep.timeout); err != nil {
+		return nil, err
+	}
+
+	if conf.Worker {
+		ep.worker = &execWorker{command: conf.Command}
+	}
+
+	return ep, nil
+}
+
+// call sends in to the plugin and decodes its answer to out
+func (ep *execPlugin) call(in interface{}, out interface{}) error {
+	msg, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("error encoding exec message: %w", err)
+	}
+
+	var answer []byte
+	switch {
+	case ep.worker != nil:
+		answer, err = ep.worker.call(msg, ep.timeout)
+	case ep.url != "":
+		answer, err = ep.post(msg)
+	default:
+		answer, err = ep.run(msg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(answer, out); err != nil {
+		return fmt.Errorf("error decoding exec answer: %w", err)
+	}
+
+	return nil
+}
+
+// run starts the command for a single message
+func (ep *execPlugin) run(msg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ep.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ep.command[0], ep.command[1:]...)
+	cmd.Stdin = bytes.NewReader(append(msg, '\n'))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("exec %s timed out after %v", ep.command[0], ep.timeout)
+		}
+		return nil, fmt.Errorf("error running %s: %w: %s", ep.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	if stderr.Len() > 0 {
+		log.Info().Str("exec", ep.command[0]).Msg(strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// post sends the message to the plugin endpoint
+func (ep *execPlugin) post(msg []byte) ([]byte, error) {
+	client := http.Client{Timeout: ep.timeout}
+	resp, err := client.Post(ep.url, "application/json", bytes.NewReader(msg))
+	if err != nil {
+		return nil, fmt.Errorf("error calling %s: %w", ep.url, err)
+	}
+	defer resp.Body.Close()
+
+	answer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading answer of %s: %w", ep.url, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %d: %s", ep.url, resp.StatusCode, strings.TrimSpace(string(answer)))
+	}
+
+	return answer, nil
+}
+
+// stop stops the worker process, if any
+func (ep *execPlugin) stop() {
+	if ep != nil && ep.worker != nil {
+		ep.worker.stop()
+	}
+}
+
+// execWorker is a long-lived command exchanging line-delimited JSON messages, one at a time. The
+// process is started on the first message and restarted on the next one after it crashes or times out,
+// until the worker is stopped
+type execWorker struct {
+	command []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	// stopped is set when the parser is removed, so late messages don't start a process nothing would stop
+	stopped bool
+}
+
+func (ew *execWorker) call(msg []byte, timeout time.Duration) ([]byte, error) {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	if ew.stopped {
+		return nil, fmt.Errorf("exec worker %s is stopped", ew.command[0])
+	}
+
+	if ew.cmd == nil {
+		if err := ew.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	type result struct {
+		line []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	stdin, stdout := ew.stdin, ew.stdout
+	go func() {
+		if _, err := stdin.Write(append(msg, '\n')); err != nil {
+			done <- result{err: err}
+			return
+		}
+		line, err := stdout.ReadBytes('\n')
+		done <- result{line: line, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			ew.kill()
+			return nil, fmt.Errorf("exec worker %s failed: %w", ew.command[0], res.err)
+		}
+		return res.line, nil
+	case <-time.After(timeout):
+		ew.kill()
+		return nil, fmt.Errorf("exec worker %s timed out after %v", ew.command[0], timeout)
+	}
+}
+
+func (ew *execWorker) start() error {
+	cmd := exec.Command(ew.command[0], ew.command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error starting exec worker: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error starting exec worker: %w", err)
+	}
+	cmd.Stderr = &logWriter{name: ew.command[0]}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting exec worker %s: %w", ew.command[0], err)
+	}
+	log.Info().Msgf("exec worker %s started (pid %d)", ew.command[0], cmd.Process.Pid)
+
+	ew.cmd = cmd
+	ew.stdin = stdin
+	ew.stdout = bufio.NewReader(stdout)
+
+	return nil
+}
+
+// kill stops the process, so the next message starts a new one. Callers must hold the lock
+func (ew *execWorker) kill() {
+	if ew.cmd == nil {
+		return
+	}
+
+	_ = ew.stdin.Close()
+	_ = ew.cmd.Process.Kill()
+	// children of the process may keep its output open, so it is reaped in the background
+	go func(cmd *exec.Cmd) { _ = cmd.Wait() }(ew.cmd)
+	ew.cmd = nil
+}
+
+func (ew *execWorker) stop() {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	ew.stopped = true
+	ew.kill()
+}
+
+// logWriter logs the stderr of workers
+type logWriter struct {
+	name string
+}
+
+func (lw *logWriter) Write(p []byte) (int, error) {
+	log.Info().Str("exec", lw.name).Msg(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// newExecRequest describes a request for exec plugins. The body is read and restored
+func newExecRequest(r *http.Request, pattern *regexp.Regexp) (execRequest, error) {
+	body, err := bufferBody(r)
+	if err != nil {
+		return execRequest{}, err
+	}
+
+	er := execRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Headers: r.Header,
+		Body:    string(body),
+	}
+
+	if pattern != nil {
+		params := pattern.FindStringSubmatch(r.URL.Path)
+		for i, name := range pattern.SubexpNames() {
+			if name != "" && i < len(params) {
+				if er.Vars == nil {
+					er.Vars = make(map[string]string)
+				}
+				er.Vars[name] = params[i]
+			}
+		}
+	}
+
+	return er, nil
+}
+
+type responseExec struct {
+	baseResponse
+	pattern *regexp.Regexp
+	plugin  messagePlugin
+}
+
+// WriteResponse writes response for exec response type
+func (re *responseExec) WriteResponse(w http.ResponseWriter, r *http.Request) {
+	in, err := newExecRequest(r, re.pattern)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Can't read body %v", err), 500)
+		return
+	}
+	in.Status = re.Status[r.Method]
+
+	var out execResponse
+	if err := re.plugin.call(in, &out); err != nil {
+		errorResponse(w, fmt.Sprintf("error running exec plugin: %v", err), 500)
+		return
+	}
+
+	re.baseResponse.addHeaders(w)
+	for k, v := range out.Headers {
+		w.Header().Set(k, v)
+	}
+
+	status := out.Status
+	if status == 0 {
+		status = in.Status
+	}
+	if !validStatus(status) {
+		errorResponse(w, fmt.Sprintf("error running exec plugin: bad status %d, it must be from 100 to 999", status), 500)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(out.Body))
+}
+
+// transformRequest sends the request to a transform plugin and applies the changes it answers.
+// Fields missing from the answer are kept
+func transformRequest(ep messagePlugin, r *http.Request) error {
+	in, err := newExecRequest(r, nil)
+	if err != nil {
+		return err
+	}
+
+	out := execRequest{Method: in.Method, Path: in.Path, Body: in.Body}
+	if err := ep.call(in, &out); err != nil {
+		return err
+	}
+
+	r.Method = out.Method
+	r.URL.Path = out.Path
+	r.URL.RawPath = ""
+	if out.Query != nil {
+		r.URL.RawQuery = out.Query.Encode()
+	}
+	if out.Headers != nil {
+		r.Header = out.Headers
+	}
+	if out.Body != in.Body {
+		r.Body = ioutil.NopCloser(strings.NewReader(out.Body))
+		r.ContentLength = int64(len(out.Body))
+		r.Header.Del("Content-Length")
+	}
+
+	return nil
+}
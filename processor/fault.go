@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// DefaultHangDuration is the time the hang fault waits before closing the connection
+const DefaultHangDuration = time.Minute
+
+// fault kinds, as recorded in the journal
+const (
+	faultAbort           = "abort"
+	faultCloseConnection = "close-connection"
+	faultReset           = "reset"
+	faultTruncateBody    = "truncate-body"
+	faultHang            = "hang"
+	faultGarbage         = "garbage"
+)
+
+// garbageSize is the number of random bytes written by the garbage fault
+const garbageSize = 512
+
+type faultChance struct {
+	kind        string
+	probability float64
+}
+
+// fault injects failures in the responses of a parser. A single random number picks at most one
+// fault per request
+type fault struct {
+	chances      []faultChance
+	abortStatus  int
+	hangDuration time.Duration
+	rand         *random
+}
+
+// newFault returns nil when no fault is configured
+func newFault(conf config.Fault, rnd *random) (*fault, error) {
+	f := &fault{
+		rand:         rnd,
+		abortStatus:  conf.Abort.Status,
+		hangDuration: DefaultHangDuration,
+	}
+
+	for _, c := range []faultChance{
+		{faultAbort, conf.Abort.Probability},
+		{faultCloseConnection, conf.CloseConnection},
+		{faultReset, conf.Reset},
+		{faultTruncateBody, conf.TruncateBody},
+		{faultHang, conf.Hang.Probability},
+		{faultGarbage, conf.Garbage},
+	} {
+		if c.probability > 0 {
+			f.chances = append(f.chances, c)
+		}
+	}
+
+	if len(f.chances) == 0 {
+		return nil, nil
+	}
+
+	if err := parseDuration("hang duration", conf.Hang.Duration, &f.hangDuration); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// pick returns the fault to inject in a request, or an empty string for none
+func (f *fault) pick() string {
+	n := f.rand.Float64()
+	for _, c := range f.chances {
+		if n < c.probability {
+			return c.kind
+		}
+		n -= c.probability
+	}
+
+	return ""
+}
+
+// inject writes the fault instead of the response. Faults that send part of the response run process
+// to get it
+func (f *fault) inject(kind string, w http.ResponseWriter, r *http.Request, process func(http.ResponseWriter, *http.Request)) {
+	switch kind {
+	case faultAbort:
+		errorResponse(w, "fault injected", f.abortStatus)
+	case faultCloseConnection:
+		closeConnection(w, false)
+	case faultReset:
+		closeConnection(w, true)
+	case faultGarbage:
+		conn := hijack(w)
+		garbage := make([]byte, garbageSize)
+		f.rand.Read(garbage)
+		_, _ = conn.Write(garbage)
+		_ = conn.Close()
+	case faultTruncateBody:
+		bw := newBufferWriter()
+		process(bw, r)
+
+		// the declared length is greater than the bytes written, so the client notices the missing bytes
+		body := bw.body.Bytes()
+		copyHeaders(w.Header(), bw.Header())
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)+1))
+		w.WriteHeader(bw.Status())
+		_, _ = w.Write(body[:len(body)/2])
+	case faultHang:
+		bw := newBufferWriter()
+		process(bw, r)
+
+		copyHeaders(w.Header(), bw.Header())
+		w.WriteHeader(bw.Status())
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(f.hangDuration):
+		}
+		closeConnection(w, false)
+	}
+}
+
+// hijack takes over the connection of the request. When the writer can't be hijacked (HTTP/2), the
+// handler is aborted, which closes the connection or resets the stream
+func hijack(w http.ResponseWriter) net.Conn {
+	if h, ok := w.(http.Hijacker); ok {
+		conn, _, err := h.Hijack()
+		if err == nil {
+			return conn
+		}
+		log.Error().Err(err).Msg("error hijacking connection")
+	}
+
+	panic(http.ErrAbortHandler)
+}
+
+// closeConnection closes the connection without a response. On reset, the socket is closed with
+// SO_LINGER 0, so a RST is sent instead of a FIN
+func closeConnection(w http.ResponseWriter, reset bool) {
+	conn := hijack(w)
+	if tcp, ok := conn.(*net.TCPConn); ok && reset {
+		_ = tcp.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+func copyHeaders(dst http.Header, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
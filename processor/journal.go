@@ -0,0 +1,221 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rodrigo-kayala/mirage-mocker/openapi"
+)
+
+// DefaultJournalSize is the number of requests kept in the journal when no size is configured
+const DefaultJournalSize = 1000
+
+// journalEntry is a request received by the processor
+type journalEntry struct {
+	ID         int64       `json:"id"`
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Query      url.Values  `json:"query,omitempty"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	Matched    bool        `json:"matched"`
+	Parser     string      `json:"parser,omitempty"`
+	Pattern    string      `json:"pattern,omitempty"`
+	Status     int         `json:"status"`
+	DurationMs float64     `json:"duration-ms"`
+
+	Violations []openapi.Violation `json:"violations,omitempty"`
+	Fault      string              `json:"fault,omitempty"`
+}
+
+// journal is a bounded in memory list of the last requests received
+type journal struct {
+	mu      sync.Mutex
+	size    int
+	nextID  int64
+	entries []journalEntry
+}
+
+func newJournal(size int) *journal {
+	if size <= 0 {
+		size = DefaultJournalSize
+	}
+
+	return &journal{size: size}
+}
+
+// add appends an entry, dropping the oldest one when the journal is full
+func (j *journal) add(e journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.nextID++
+	e.ID = j.nextID
+
+	if len(j.entries) >= j.size {
+		copy(j.entries, j.entries[1:])
+		j.entries = j.entries[:len(j.entries)-1]
+	}
+	j.entries = append(j.entries, e)
+}
+
+// find returns the entries matching the filter, oldest first
+func (j *journal) find(f journalFilter) []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	out := []journalEntry{}
+	for _, e := range j.entries {
+		if f.match(e) {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
+
+func (j *journal) clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries = nil
+}
+
+// journalFilter selects journal entries. Empty fields match everything
+type journalFilter struct {
+	Method       string
+	Path         *regexp.Regexp
+	Parser       string
+	Status       int
+	Matched      *bool
+	Invalid      *bool
+	Headers      map[string]string
+	Query        map[string]string
+	BodyContains string
+	BodyJSON     interface{}
+}
+
+// parseJournalFilter reads a filter from query parameters: method, path (regex), parser, status, matched,
+// invalid (requests with spec violations), header (Name:Value, repeatable), query (name=value, repeatable), body-contains and body-json
+func parseJournalFilter(values url.Values) (journalFilter, error) {
+	f := journalFilter{
+		Method:       values.Get("method"),
+		Parser:       values.Get("parser"),
+		BodyContains: values.Get("body-contains"),
+		Headers:      make(map[string]string),
+		Query:        make(map[string]string),
+	}
+
+	if v := values.Get("path"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return journalFilter{}, fmt.Errorf("bad path regex: %w", err)
+		}
+		f.Path = re
+	}
+
+	if v := values.Get("status"); v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			return journalFilter{}, fmt.Errorf("bad status %s", v)
+		}
+		f.Status = status
+	}
+
+	if v := values.Get("matched"); v != "" {
+		matched, err := strconv.ParseBool(v)
+		if err != nil {
+			return journalFilter{}, fmt.Errorf("bad matched %s", v)
+		}
+		f.Matched = &matched
+	}
+
+	if v := values.Get("invalid"); v != "" {
+		invalid, err := strconv.ParseBool(v)
+		if err != nil {
+			return journalFilter{}, fmt.Errorf("bad invalid %s", v)
+		}
+		f.Invalid = &invalid
+	}
+
+	for _, h := range values["header"] {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return journalFilter{}, fmt.Errorf("bad header filter %s, expected Name:Value", h)
+		}
+		f.Headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	for _, q := range values["query"] {
+		parts := strings.SplitN(q, "=", 2)
+		if len(parts) != 2 {
+			return journalFilter{}, fmt.Errorf("bad query filter %s, expected name=value", q)
+		}
+		f.Query[parts[0]] = parts[1]
+	}
+
+	if v := values.Get("body-json"); v != "" {
+		if err := json.Unmarshal([]byte(v), &f.BodyJSON); err != nil {
+			return journalFilter{}, fmt.Errorf("bad body-json: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+func (f journalFilter) match(e journalEntry) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, e.Method) {
+		return false
+	}
+
+	if f.Path != nil && !f.Path.MatchString(e.Path) {
+		return false
+	}
+
+	if f.Parser != "" && f.Parser != e.Parser {
+		return false
+	}
+
+	if f.Status != 0 && f.Status != e.Status {
+		return false
+	}
+
+	if f.Matched != nil && *f.Matched != e.Matched {
+		return false
+	}
+
+	if f.Invalid != nil && *f.Invalid != (len(e.Violations) > 0) {
+		return false
+	}
+
+	if !matchHeaders(e.Headers, f.Headers) {
+		return false
+	}
+
+	for k, v := range f.Query {
+		if !containsString(e.Query[k], v) {
+			return false
+		}
+	}
+
+	if f.BodyContains != "" && !strings.Contains(e.Body, f.BodyContains) {
+		return false
+	}
+
+	if f.BodyJSON != nil {
+		var body interface{}
+		if err := json.Unmarshal([]byte(e.Body), &body); err != nil || !containsJSON(body, f.BodyJSON) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled JSONPath expression. Only a subset of the syntax is supported:
+// $, .key, ['key'], [index], [*] and .*
+type jsonPath []jsonPathStep
+
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseJSONPath(expr string) (jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("json path %s must start with $", expr)
+	}
+
+	var path jsonPath
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if key == "" {
+				return nil, fmt.Errorf("empty key in json path %s", expr)
+			}
+			rest = rest[end:]
+
+			if key == "*" {
+				path = append(path, jsonPathStep{wildcard: true})
+				continue
+			}
+			path = append(path, jsonPathStep{key: key})
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket in json path %s", expr)
+			}
+			sel := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			switch {
+			case sel == "*":
+				path = append(path, jsonPathStep{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				path = append(path, jsonPathStep{key: sel[1 : len(sel)-1]})
+			default:
+				i, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("bad index %s in json path %s", sel, expr)
+				}
+				path = append(path, jsonPathStep{index: i, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected character %q in json path %s", rest[0], expr)
+		}
+	}
+
+	return path, nil
+}
+
+// eval returns all values selected by the path
+func (p jsonPath) eval(doc interface{}) []interface{} {
+	current := []interface{}{doc}
+	for _, step := range p {
+		var next []interface{}
+		for _, v := range current {
+			next = append(next, step.apply(v)...)
+		}
+		current = next
+	}
+
+	return current
+}
+
+func (s jsonPathStep) apply(v interface{}) []interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			out := make([]interface{}, 0, len(node))
+			for _, child := range node {
+				out = append(out, child)
+			}
+			return out
+		}
+		if child, ok := node[s.key]; ok && !s.isIndex {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		if s.wildcard {
+			return node
+		}
+		if s.isIndex {
+			i := s.index
+			if i < 0 {
+				i += len(node)
+			}
+			if i >= 0 && i < len(node) {
+				return []interface{}{node[i]}
+			}
+		}
+	}
+
+	return nil
+}
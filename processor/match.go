@@ -0,0 +1,318 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// queryMatcher matches a single query parameter
+type queryMatcher struct {
+	Name    string
+	Equals  string
+	Matches *regexp.Regexp
+	Values  []string
+	Present *bool
+}
+
+func createQueryMatchers(conf map[string]config.Query) ([]queryMatcher, error) {
+	var matchers []queryMatcher
+	for name, q := range conf {
+		m := queryMatcher{
+			Name:    name,
+			Equals:  q.Equals,
+			Values:  q.Values,
+			Present: q.Present,
+		}
+
+		if q.Matches != "" {
+			re, err := regexp.Compile(q.Matches)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing query %s regex: %w", name, err)
+			}
+			m.Matches = re
+		}
+
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
+
+func (m queryMatcher) match(query url.Values) bool {
+	values, present := query[m.Name]
+
+	if m.Present != nil {
+		if *m.Present != present {
+			return false
+		}
+		if !present {
+			return true
+		}
+	}
+
+	if m.Equals != "" && !containsString(values, m.Equals) {
+		return false
+	}
+
+	if m.Matches != nil && !anyMatches(values, m.Matches) {
+		return false
+	}
+
+	for _, v := range m.Values {
+		if !containsString(values, v) {
+			return false
+		}
+	}
+
+	return present || m.Present != nil
+}
+
+// clientCertMatcher matches the certificate of TLS clients
+type clientCertMatcher struct {
+	commonName string
+	subject    *regexp.Regexp
+}
+
+// createClientCertMatcher returns nil when no client certificate is required
+func createClientCertMatcher(conf config.ClientCert) (*clientCertMatcher, error) {
+	if conf.CommonName == "" && conf.Subject == "" {
+		return nil, nil
+	}
+
+	m := &clientCertMatcher{commonName: conf.CommonName}
+	if conf.Subject != "" {
+		re, err := regexp.Compile(conf.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing client cert subject regex: %w", err)
+		}
+		m.subject = re
+	}
+
+	return m, nil
+}
+
+// match checks the first certificate sent by the client. Requests without one don't match
+func (m *clientCertMatcher) match(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	if m.commonName != "" && cert.Subject.CommonName != m.commonName {
+		return false
+	}
+
+	return m.subject == nil || m.subject.MatchString(cert.Subject.String())
+}
+
+func matchQuery(query url.Values, matchers []queryMatcher) bool {
+	for _, m := range matchers {
+		if !m.match(query) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyMatches(values []string, re *regexp.Regexp) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody lazily reads and parses the request body, so it is done at most once per request
+type requestBody struct {
+	read bool
+	doc  interface{}
+	err  error
+}
+
+func (rb *requestBody) json(r *http.Request) (interface{}, error) {
+	if !rb.read {
+		rb.read = true
+
+		var b []byte
+		b, rb.err = bufferBody(r)
+		if rb.err == nil {
+			rb.err = json.Unmarshal(b, &rb.doc)
+		}
+	}
+
+	return rb.doc, rb.err
+}
+
+// bodyMatcher matches the JSON request body
+type bodyMatcher struct {
+	Equals   interface{}
+	Contains interface{}
+	JSONPath []jsonPathMatcher
+}
+
+type jsonPathMatcher struct {
+	Path    jsonPath
+	Equals  string
+	Matches *regexp.Regexp
+	Exists  *bool
+}
+
+func createBodyMatcher(conf config.BodyMatcher) (*bodyMatcher, error) {
+	if conf.Equals == "" && conf.Contains == "" && len(conf.JSONPath) == 0 {
+		return nil, nil
+	}
+
+	var m bodyMatcher
+	if conf.Equals != "" {
+		if err := json.Unmarshal([]byte(conf.Equals), &m.Equals); err != nil {
+			return nil, fmt.Errorf("error parsing body equals json: %w", err)
+		}
+	}
+
+	if conf.Contains != "" {
+		if err := json.Unmarshal([]byte(conf.Contains), &m.Contains); err != nil {
+			return nil, fmt.Errorf("error parsing body contains json: %w", err)
+		}
+	}
+
+	for _, jp := range conf.JSONPath {
+		path, err := parseJSONPath(jp.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		pm := jsonPathMatcher{
+			Path:   path,
+			Equals: jp.Equals,
+			Exists: jp.Exists,
+		}
+
+		if jp.Matches != "" {
+			pm.Matches, err = regexp.Compile(jp.Matches)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing json path %s regex: %w", jp.Path, err)
+			}
+		}
+
+		m.JSONPath = append(m.JSONPath, pm)
+	}
+
+	return &m, nil
+}
+
+func (m *bodyMatcher) match(body interface{}) bool {
+	if m.Equals != nil && !reflect.DeepEqual(m.Equals, body) {
+		return false
+	}
+
+	if m.Contains != nil && !containsJSON(body, m.Contains) {
+		return false
+	}
+
+	for _, pm := range m.JSONPath {
+		if !pm.match(body) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m jsonPathMatcher) match(body interface{}) bool {
+	values := m.Path.eval(body)
+
+	if m.Exists != nil {
+		if *m.Exists != (len(values) > 0) {
+			return false
+		}
+		if !*m.Exists {
+			return true
+		}
+	}
+
+	if len(values) == 0 {
+		return false
+	}
+
+	if m.Equals == "" && m.Matches == nil {
+		return true
+	}
+
+	for _, v := range values {
+		if (m.Equals == "" || jsonValueEquals(v, m.Equals)) &&
+			(m.Matches == nil || m.Matches.MatchString(jsonValueString(v))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jsonValueEquals compares strings as is and any other value by its JSON representation
+func jsonValueEquals(v interface{}, expected string) bool {
+	if s, ok := v.(string); ok {
+		return s == expected
+	}
+
+	var e interface{}
+	if err := json.Unmarshal([]byte(expected), &e); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(v, e)
+}
+
+func jsonValueString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// containsJSON tells if expected is a subset of actual: objects must have at least the expected keys and
+// every expected array element must be contained in some element of the actual array
+func containsJSON(actual interface{}, expected interface{}) bool {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, ev := range e {
+			av, ok := a[k]
+			if !ok || !containsJSON(av, ev) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, ev := range e {
+			found := false
+			for _, av := range a {
+				if containsJSON(av, ev) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(actual, expected)
+	}
+}
@@ -36,14 +36,24 @@ type response interface {
 }
 
 type baseResponse struct {
-	Status  map[string]int
-	Headers map[string]string
+	Status            map[string]int
+	Headers           map[string]string
+	MultiValueHeaders map[string][]string
 }
 
 func (br *baseResponse) addHeaders(w http.ResponseWriter) {
 	for k, v := range br.Headers {
 		w.Header().Add(k, v)
 	}
+	br.addMultiValueHeaders(w)
+}
+
+func (br *baseResponse) addMultiValueHeaders(w http.ResponseWriter) {
+	for k, values := range br.MultiValueHeaders {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
 }
 
 type responseFixed struct {
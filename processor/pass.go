@@ -1,11 +1,16 @@
 package processor
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"text/template"
 
 	"github.com/rs/zerolog/log"
 
@@ -17,16 +22,100 @@ type transform struct {
 	tranformFunc func(r *http.Request) error
 }
 
+// Response transform plugin structure
+type responseTransform struct {
+	transformFunc func(resp *http.Response) error
+}
+
+// rewrite is a compiled path rewrite
+type rewrite struct {
+	source *regexp.Regexp
+	target string
+}
+
 // passParser type structure
 type passParser struct {
 	baseParser
-	proxy     *httputil.ReverseProxy
-	transform transform
+	proxy         *httputil.ReverseProxy
+	transform     transform
+	transformExec *execPlugin
+	transformWasm *wasmPlugin
+	recorder      *recorder
+	fallback      *passFallback
+	balancer      *balancer
+	transport     *upstreamTransport
+}
+
+// passFallback is the mock response served when the upstream fails
+type passFallback struct {
+	response response
+	statuses []int
+}
+
+// errFallbackStatus is returned by ModifyResponse for upstream statuses that must be answered with the fallback
+var errFallbackStatus = errors.New("fallback status")
+
+type fallbackKey struct{}
+
+// fallbackRequest is the state of a request to a pass parser with fallback, kept in its context
+type fallbackRequest struct {
+	// request is the client request, since the fallback response is rendered from it and not from
+	// the request sent upstream
+	request *http.Request
+	body    []byte
+}
+
+type proxyErrorKey struct{}
+
+// proxyError tells if the upstream of a recorded request failed, kept in its context, so the response
+// written by the error handler is not recorded
+type proxyError struct {
+	failed bool
 }
 
 // ProcessRequest process pass requests
 func (pp passParser) ProcessRequest(w http.ResponseWriter, r *http.Request) {
-	pp.proxy.ServeHTTP(w, r)
+	if pp.balancer != nil {
+		r = r.WithContext(context.WithValue(r.Context(), upstreamKey{}, pp.balancer.pick(r)))
+	}
+
+	if pp.fallback != nil {
+		// the body is kept, since the fallback response may need it after the proxy read it
+		body, err := bufferBody(r)
+		if err != nil {
+			errorResponse(w, fmt.Sprintf("Can't read body %v", err), 500)
+			return
+		}
+		fr := &fallbackRequest{request: r, body: body}
+		r = r.WithContext(context.WithValue(r.Context(), fallbackKey{}, fr))
+	}
+
+	if pp.recorder == nil {
+		pp.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := bufferBody(r)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Can't read body %v", err), 500)
+		return
+	}
+
+	pe := &proxyError{}
+	r = r.WithContext(context.WithValue(r.Context(), proxyErrorKey{}, pe))
+
+	cw := newCaptureWriter(w, true)
+	pp.proxy.ServeHTTP(cw, r)
+
+	// the 502 and fallback responses of failed upstreams are not recorded, they didn't come from the
+	// upstream and would replace a good recording
+	if pe.failed {
+		return
+	}
+
+	if err := pp.recorder.record(newExchange(r, body, pp.recorder.headers, cw)); err != nil {
+		log.Error().Err(err).Msg("error recording pass request")
+	}
 }
 
 // GetBaseParser returns base request
@@ -34,12 +123,14 @@ func (pp passParser) GetBaseParser() baseParser {
 	return pp.baseParser
 }
 
-type logTransport struct{}
+type logTransport struct {
+	next http.RoundTripper
+}
 
 func (t *logTransport) RoundTrip(request *http.Request) (*http.Response, error) {
 	logRequest(request)
 
-	response, err := http.DefaultTransport.RoundTrip(request)
+	response, err := t.next.RoundTrip(request)
 	if err != nil {
 		return response, err
 	}
@@ -53,22 +144,59 @@ func createPassParser(base baseParser, cr config.Parser) (passParser, error) {
 	var parser passParser
 	parser.baseParser = base
 
-	url, err := url.Parse(cr.PassBaseURI)
-	if err != nil {
-		return passParser{}, fmt.Errorf("error parsing pass url %s: %w", cr.PassBaseURI, err)
-	}
+	var target *url.URL
+	var err error
+	if len(cr.PassBaseURIs) > 0 {
+		parser.balancer, err = newBalancer(cr, base.rand)
+		if err != nil {
+			return passParser{}, err
+		}
+	} else {
+		target, err = url.Parse(cr.PassBaseURI)
+		if err != nil {
+			return passParser{}, fmt.Errorf("error parsing pass url %s: %w", cr.PassBaseURI, err)
+		}
 
-	log.Debug().Msgf("PASS URL: %v", url)
+		log.Debug().Msgf("PASS URL: %v", target)
+	}
 
 	var transf transform
 
-	if cr.TransformLib != "" && cr.TransformSymbol != "" {
+	if cr.TransformLib != "" && cr.TransformSymbol != "" && isWasm(cr.TransformLib) {
+		parser.transformWasm, err = newWasmPlugin(cr.TransformLib, cr.TransformSymbol)
+		if err != nil {
+			return passParser{}, fmt.Errorf("error loading wasm transform: %w", err)
+		}
+		transf = wasmTransform(parser.transformWasm)
+	} else if cr.TransformLib != "" && cr.TransformSymbol != "" {
 		transf, err = loadTransformFunc(cr.TransformLib, cr.TransformSymbol)
 		if err != nil {
 			return passParser{}, fmt.Errorf("error loading tranform funcion: %w", err)
 		}
 	}
 
+	if len(cr.TransformExec.Command) > 0 || cr.TransformExec.URL != "" {
+		parser.transformExec, err = newExecPlugin(cr.TransformExec)
+		if err != nil {
+			return passParser{}, fmt.Errorf("error loading transform exec: %w", err)
+		}
+	}
+	transformExec := parser.transformExec
+
+	modifier, err := newRequestModifier(cr.ModifyRequest, base.pattern)
+	if err != nil {
+		return passParser{}, err
+	}
+
+	rewrites := make([]rewrite, 0, len(cr.Rewrites))
+	for _, rw := range cr.Rewrites {
+		regex, err := regexp.Compile(rw.Source)
+		if err != nil {
+			return passParser{}, fmt.Errorf("error parsing rewrite %s: %w", rw.Source, err)
+		}
+		rewrites = append(rewrites, rewrite{source: regex, target: rw.Target})
+	}
+
 	director := func(req *http.Request) {
 		if transf.tranformFunc != nil {
 			err := transf.tranformFunc(req)
@@ -77,29 +205,85 @@ func createPassParser(base baseParser, cr config.Parser) (passParser, error) {
 			}
 		}
 
-		req.Host = url.Host
-		req.URL.Scheme = url.Scheme
-		req.URL.Host = url.Host
+		if transformExec != nil {
+			if err := transformRequest(transformExec, req); err != nil {
+				log.Error().Err(err).Msg("error transforming pass request")
+			}
+		}
 
-		for _, rw := range cr.Rewrites {
+		upstreamURL := target
+		if u, ok := req.Context().Value(upstreamKey{}).(*upstream); ok {
+			upstreamURL = u.url
+		}
 
-			regex, err := regexp.Compile(rw.Source)
+		req.Host = upstreamURL.Host
+		req.URL.Scheme = upstreamURL.Scheme
+		req.URL.Host = upstreamURL.Host
 
-			if err != nil {
-				log.Error().Err(err).Msg("error parsing rewrite")
-				continue
+		if modifier != nil {
+			if err := modifier.apply(req); err != nil {
+				log.Error().Err(err).Msg("error modifying pass request")
 			}
+		}
 
-			req.URL.Path = regex.ReplaceAllString(req.URL.Path, rw.Target)
+		for _, rw := range rewrites {
+			req.URL.Path = rw.source.ReplaceAllString(req.URL.Path, rw.target)
 		}
 
 		log.Debug().Msgf("URL After rewrite: %v", req.URL)
 	}
 
-	proxy := httputil.NewSingleHostReverseProxy(url)
-	proxy.Director = director
+	proxy := &httputil.ReverseProxy{Director: director}
+
+	modify, err := createModifyResponse(cr)
+	if err != nil {
+		return passParser{}, err
+	}
+	proxy.ModifyResponse = modify
+
+	errorHandler := badGateway(base.Config.Name)
+	if cr.Fallback.Response.BodyType != "" {
+		baseResp := baseResponse{
+			Status:            cr.Fallback.Response.Status,
+			Headers:           cr.Fallback.Response.Headers,
+			MultiValueHeaders: cr.Fallback.Response.MultiValueHeaders,
+		}
+		resp, err := parseMockResponseConfig(cr.Fallback.Response, baseResp, base.pattern)
+		if err != nil {
+			return passParser{}, fmt.Errorf("error parsing fallback response: %w", err)
+		}
+
+		parser.fallback = &passFallback{response: resp, statuses: cr.Fallback.OnStatus}
+		proxy.ModifyResponse = parser.fallback.modifyResponse(modify)
+		errorHandler = parser.fallback.errorHandler(base.Config.Name)
+	}
+	proxy.ErrorHandler = markFailed(errorHandler)
+
+	parser.transport, err = createTransport(cr)
+	if err != nil {
+		return passParser{}, err
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if parser.transport != nil {
+		transport = parser.transport
+	}
 	if cr.Log {
-		proxy.Transport = &logTransport{}
+		transport = &logTransport{next: transport}
+	}
+	if base.latency != nil && base.latency.after {
+		transport = &delayTransport{latency: base.latency, rand: base.rand, next: transport}
+	}
+	if parser.balancer != nil {
+		transport = &balancerTransport{balancer: parser.balancer, next: transport}
+	}
+	proxy.Transport = transport
+
+	if cr.Record.Dir != "" {
+		parser.recorder, err = newRecorder(cr.Record, mockKey)
+		if err != nil {
+			return passParser{}, err
+		}
 	}
 
 	parser.proxy = proxy
@@ -107,3 +291,205 @@ func createPassParser(base baseParser, cr config.Parser) (passParser, error) {
 
 	return parser, nil
 }
+
+// requestModifier applies the declarative changes to the requests of pass parsers. Templates see the
+// request before these changes
+type requestModifier struct {
+	pattern       *regexp.Regexp
+	host          *template.Template
+	setHeaders    map[string]*template.Template
+	addHeaders    map[string]*template.Template
+	removeHeaders []string
+	setQuery      map[string]*template.Template
+	removeQuery   []string
+}
+
+// newRequestModifier compiles the changes. It returns nil when there is nothing to do
+func newRequestModifier(mr config.ModifyRequest, pattern *regexp.Regexp) (*requestModifier, error) {
+	if mr.Host == "" && len(mr.SetHeaders) == 0 && len(mr.AddHeaders) == 0 && len(mr.RemoveHeaders) == 0 &&
+		len(mr.SetQuery) == 0 && len(mr.RemoveQuery) == 0 {
+		return nil, nil
+	}
+
+	rm := &requestModifier{
+		pattern:       pattern,
+		removeHeaders: mr.RemoveHeaders,
+		removeQuery:   mr.RemoveQuery,
+	}
+
+	var err error
+	if mr.Host != "" {
+		if rm.host, err = parseTemplate("host", mr.Host); err != nil {
+			return nil, fmt.Errorf("error parsing modify-request host: %w", err)
+		}
+	}
+	if rm.setHeaders, err = parseTemplates("set-headers", mr.SetHeaders); err != nil {
+		return nil, err
+	}
+	if rm.addHeaders, err = parseTemplates("add-headers", mr.AddHeaders); err != nil {
+		return nil, err
+	}
+	if rm.setQuery, err = parseTemplates("set-query", mr.SetQuery); err != nil {
+		return nil, err
+	}
+
+	return rm, nil
+}
+
+func parseTemplates(field string, values map[string]string) (map[string]*template.Template, error) {
+	out := make(map[string]*template.Template, len(values))
+	for k, v := range values {
+		t, err := parseTemplate(k, v)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing modify-request %s %s: %w", field, k, err)
+		}
+		out[k] = t
+	}
+
+	return out, nil
+}
+
+func (rm *requestModifier) apply(req *http.Request) error {
+	data, err := newTemplateData(req, rm.pattern)
+	if err != nil {
+		return err
+	}
+	// the header and query changes must not change the data of the next templates
+	data.Headers = req.Header.Clone()
+
+	for _, h := range rm.removeHeaders {
+		req.Header.Del(h)
+	}
+	for k, t := range rm.setHeaders {
+		v, err := executeTemplate(t, data)
+		if err != nil {
+			return fmt.Errorf("error executing header %s template: %w", k, err)
+		}
+		req.Header.Set(k, v)
+	}
+	for k, t := range rm.addHeaders {
+		v, err := executeTemplate(t, data)
+		if err != nil {
+			return fmt.Errorf("error executing header %s template: %w", k, err)
+		}
+		req.Header.Add(k, v)
+	}
+
+	if len(rm.removeQuery) > 0 || len(rm.setQuery) > 0 {
+		query := req.URL.Query()
+		for _, q := range rm.removeQuery {
+			query.Del(q)
+		}
+		for k, t := range rm.setQuery {
+			v, err := executeTemplate(t, data)
+			if err != nil {
+				return fmt.Errorf("error executing query %s template: %w", k, err)
+			}
+			query.Set(k, v)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	if rm.host != nil {
+		host, err := executeTemplate(rm.host, data)
+		if err != nil {
+			return fmt.Errorf("error executing host template: %w", err)
+		}
+		req.Host = host
+	}
+
+	return nil
+}
+
+// createModifyResponse creates the function changing the upstream responses: the transform response
+// plugin runs first, then the declarative changes are applied. It returns nil when there is nothing to do
+func createModifyResponse(cr config.Parser) (func(*http.Response) error, error) {
+	var transf responseTransform
+	if cr.TransformLib != "" && cr.TransformResponseSymbol != "" {
+		var err error
+		transf, err = loadResponseTransformFunc(cr.TransformLib, cr.TransformResponseSymbol)
+		if err != nil {
+			return nil, fmt.Errorf("error loading transform response function: %w", err)
+		}
+	}
+
+	mr := cr.ModifyResponse
+	if transf.transformFunc == nil && mr.Status == 0 && len(mr.SetHeaders) == 0 && len(mr.RemoveHeaders) == 0 {
+		return nil, nil
+	}
+
+	return func(resp *http.Response) error {
+		if transf.transformFunc != nil {
+			if err := transf.transformFunc(resp); err != nil {
+				return fmt.Errorf("error transforming pass response: %w", err)
+			}
+		}
+
+		for _, h := range mr.RemoveHeaders {
+			resp.Header.Del(h)
+		}
+
+		for k, v := range mr.SetHeaders {
+			resp.Header.Set(k, v)
+		}
+
+		if mr.Status != 0 {
+			resp.StatusCode = mr.Status
+			resp.Status = fmt.Sprintf("%d %s", mr.Status, http.StatusText(mr.Status))
+		}
+
+		return nil
+	}, nil
+}
+
+// modifyResponse checks the upstream status before the other response changes
+func (pf *passFallback) modifyResponse(next func(*http.Response) error) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		for _, status := range pf.statuses {
+			if resp.StatusCode == status {
+				return fmt.Errorf("%w %d", errFallbackStatus, status)
+			}
+		}
+
+		if next != nil {
+			return next(resp)
+		}
+		return nil
+	}
+}
+
+// errorHandler serves the fallback response when the upstream fails
+func (pf *passFallback) errorHandler(name string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, context.Canceled) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		log.Warn().Err(err).Str("parser", name).Msgf("upstream failed for %s %s, serving fallback response", r.Method, r.URL.Path)
+
+		if fr, ok := r.Context().Value(fallbackKey{}).(*fallbackRequest); ok {
+			r = fr.request.Clone(r.Context())
+			r.Body = ioutil.NopCloser(bytes.NewReader(fr.body))
+		}
+		pf.response.WriteResponse(w, r)
+	}
+}
+
+// markFailed marks the request as failed, so its response is not recorded, before answering with next
+func markFailed(next func(http.ResponseWriter, *http.Request, error)) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if pe, ok := r.Context().Value(proxyErrorKey{}).(*proxyError); ok {
+			pe.failed = true
+		}
+		next(w, r, err)
+	}
+}
+
+// badGateway answers 502 when the upstream fails, like the default error handler of the reverse proxy
+func badGateway(name string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Error().Err(err).Str("parser", name).Msgf("upstream failed for %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/rodrigo-kayala/mirage-mocker/config"
+	"github.com/rodrigo-kayala/mirage-mocker/openapi"
 )
 
 var (
@@ -20,11 +23,21 @@ var (
 
 type Processor interface {
 	Process(w http.ResponseWriter, r *http.Request)
+	Admin() http.Handler
+	Done() <-chan struct{}
+	Reload(c config.Config) error
 }
 
 // Processor structure
 type processor struct {
-	Parsers []parser
+	Parsers   []parser
+	routes    *router
+	mu        sync.RWMutex
+	scenarios *scenarioStore
+	journal   *journal
+	rand      *random
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // Parser interface
@@ -35,6 +48,29 @@ type parser interface {
 
 // Process current request and write response
 func (rp *processor) Process(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, err := bufferBody(r)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Can't read body %v", err), 500)
+		return
+	}
+
+	entry := journalEntry{
+		Time:    start,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Headers: r.Header.Clone(),
+		Body:    string(body),
+	}
+	cw := newCaptureWriter(w, false)
+	w = cw
+	defer func() {
+		entry.Status = cw.Status()
+		entry.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+		rp.journal.add(entry)
+	}()
+
 	requestProcess, err := rp.matchParser(r)
 	log.Debug().Msgf("requestProcess: %#v", requestProcess)
 
@@ -48,19 +84,50 @@ func (rp *processor) Process(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	base := requestProcess.GetBaseParser()
-	delay(base.MinDelay, base.MaxDelay)
+	entry.Matched = true
+	entry.Parser = base.Config.Name
+	entry.Pattern = base.Pattern
+
+	if base.validator != nil {
+		if violations := base.validator.Validate(r, body); len(violations) > 0 {
+			entry.Violations = violations
+			if base.rejectInvalid {
+				errorResponse(w, violationsMessage(violations), http.StatusBadRequest)
+				return
+			}
+			log.Warn().Msg(violationsMessage(violations))
+		}
+	}
+
+	if base.latency != nil && !base.latency.after {
+		base.latency.wait(base.rand)
+	}
+
+	if base.throttle != nil {
+		w = base.throttle.wrap(w)
+	}
+
+	if base.fault != nil {
+		if kind := base.fault.pick(); kind != "" {
+			entry.Fault = kind
+			log.Info().Str("parser", base.Config.Name).Msgf("injecting fault %s for %s %s", kind, r.Method, r.URL.Path)
+			base.fault.inject(kind, w, r, requestProcess.ProcessRequest)
+			return
+		}
+	}
 
 	requestProcess.ProcessRequest(w, r)
 }
 
-func delay(min time.Duration, max time.Duration) {
-	delta := int64(max - min)
-	if delta <= 0 {
-		return
+func violationsMessage(violations []openapi.Violation) string {
+	var b strings.Builder
+	b.WriteString("request does not match the spec:")
+	for _, v := range violations {
+		b.WriteString("\n- ")
+		b.WriteString(v.String())
 	}
-	d := rand.Int63n(delta) + int64(min)
 
-	time.Sleep(time.Duration(d))
+	return b.String()
 }
 
 func matchHeaders(header http.Header, expectedHeaders map[string]string) bool {
@@ -72,113 +139,470 @@ func matchHeaders(header http.Header, expectedHeaders map[string]string) bool {
 	return true
 }
 
+// Done returns a channel closed when a shutdown is requested through the admin API
+func (rp *processor) Done() <-chan struct{} {
+	return rp.done
+}
+
+// parsers returns the current parsers. The returned slice is never modified, parser changes replace it
+func (rp *processor) parsers() []parser {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	return rp.Parsers
+}
+
+// router returns the routing index of the current parsers
+func (rp *processor) router() *router {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	return rp.routes
+}
+
+// setParsers replaces the parsers, stopping the background resources of the removed ones, and rebuilds
+// the routing index. Callers must hold the write lock
+func (rp *processor) setParsers(parsers []parser) {
+	stopRemoved(rp.Parsers, parsers)
+	rp.Parsers = parsers
+	rp.routes = newRouter(parsers)
+}
+
+// stopper is a resource of a parser running in the background, like exec workers, health checks and
+// upstream connections
+type stopper interface {
+	stop()
+}
+
+// stopRemoved stops the background resources of the parsers that are not in the new parsers
+func stopRemoved(old []parser, parsers []parser) {
+	keep := make(map[stopper]bool)
+	for _, p := range parsers {
+		for _, s := range parserStoppers(p) {
+			keep[s] = true
+		}
+	}
+
+	for _, p := range old {
+		for _, s := range parserStoppers(p) {
+			if !keep[s] {
+				s.stop()
+			}
+		}
+	}
+}
+
+func parserStoppers(p parser) []stopper {
+	var out []stopper
+	switch p := p.(type) {
+	case *mockParser:
+		if re, ok := p.Response.(*responseExec); ok {
+			out = append(out, re.plugin)
+		}
+	case passParser:
+		if p.transformExec != nil {
+			out = append(out, p.transformExec)
+		}
+		if p.transformWasm != nil {
+			out = append(out, p.transformWasm)
+		}
+		if p.fallback != nil {
+			if re, ok := p.fallback.response.(*responseExec); ok {
+				out = append(out, re.plugin)
+			}
+		}
+		if p.balancer != nil {
+			out = append(out, p.balancer)
+		}
+		if p.transport != nil {
+			out = append(out, p.transport)
+		}
+	case *replayParser:
+		if p.pass != nil {
+			out = parserStoppers(*p.pass)
+		}
+	}
+
+	return out
+}
+
+// indexOf returns the index of a parser by its name or index
+func indexOf(parsers []parser, id string) (int, bool) {
+	for i, p := range parsers {
+		if p.GetBaseParser().Config.Name == id {
+			return i, true
+		}
+	}
+
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 0 || i >= len(parsers) {
+		return 0, false
+	}
+	return i, true
+}
+
+// parserByID returns a parser and its index by its name or index
+func (rp *processor) parserByID(id string) (int, parser, bool) {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	index, ok := indexOf(rp.Parsers, id)
+	if !ok {
+		return 0, nil, false
+	}
+	return index, rp.Parsers[index], true
+}
+
+// insertParser adds a parser at index, or at the end if index is negative
+func (rp *processor) insertParser(index int, p parser) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if index < 0 {
+		index = len(rp.Parsers)
+	}
+	if index > len(rp.Parsers) {
+		return 0, fmt.Errorf("index %d out of range", index)
+	}
+
+	parsers := make([]parser, 0, len(rp.Parsers)+1)
+	parsers = append(parsers, rp.Parsers[:index]...)
+	parsers = append(parsers, p)
+	parsers = append(parsers, rp.Parsers[index:]...)
+	rp.setParsers(parsers)
+
+	return index, nil
+}
+
+// replaceParserByID replaces the parser with a name or index, returning its index
+func (rp *processor) replaceParserByID(id string, p parser) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	index, ok := indexOf(rp.Parsers, id)
+	if !ok {
+		return 0, fmt.Errorf("parser %s not found", id)
+	}
+
+	parsers := append([]parser(nil), rp.Parsers...)
+	parsers[index] = p
+	rp.setParsers(parsers)
+
+	return index, nil
+}
+
+// deleteParserByID removes the parser with a name or index
+func (rp *processor) deleteParserByID(id string) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	index, ok := indexOf(rp.Parsers, id)
+	if !ok {
+		return fmt.Errorf("parser %s not found", id)
+	}
+
+	parsers := make([]parser, 0, len(rp.Parsers)-1)
+	parsers = append(parsers, rp.Parsers[:index]...)
+	parsers = append(parsers, rp.Parsers[index+1:]...)
+	rp.setParsers(parsers)
+
+	return nil
+}
+
 func (rp *processor) matchParser(r *http.Request) (parser, error) {
-	log.Debug().Msgf("parsers: %#v", rp.Parsers)
-	for _, parser := range rp.Parsers {
+	var body requestBody
+	for _, parser := range rp.router().candidates(r.URL.Path) {
 		bp := parser.GetBaseParser()
+		if !containsString(bp.Methods, r.Method) {
+			continue
+		}
+
+		if !bp.pattern.MatchString(r.URL.Path) {
+			continue
+		}
+
 		if !matchHeaders(r.Header, bp.Headers) {
 			continue
 		}
 
-		if !containsMethod(bp.Methods, r.Method) {
+		if !matchQuery(r.URL.Query(), bp.Query) {
 			continue
 		}
 
-		match, err := regexp.MatchString(bp.Pattern, r.URL.Path)
-		if err != nil {
-			return nil, err
+		if bp.ClientCert != nil && !bp.ClientCert.match(r) {
+			continue
 		}
 
-		if match {
-			return parser, nil
+		if bp.Body != nil {
+			doc, err := body.json(r)
+			if err != nil || !bp.Body.match(doc) {
+				continue
+			}
+		}
+
+		// the scenario is checked last, and moved to the new state in the same step, so concurrent
+		// requests can't both match the same state
+		if bp.Scenario.Name != "" &&
+			!rp.scenarios.advance(bp.Scenario.Name, bp.Scenario.RequiredState, bp.Scenario.NewState) {
+			continue
 		}
+
+		return parser, nil
 	}
 	return nil, ErrNoMatchFound
 }
 
 // baseParser base structure
 type baseParser struct {
-	Pattern  string
-	Methods  []string
-	Headers  map[string]string
-	Log      bool
-	MinDelay time.Duration
-	MaxDelay time.Duration
+	Config     config.Parser
+	Pattern    string
+	pattern    *regexp.Regexp
+	Methods    []string
+	Headers    map[string]string
+	Query      []queryMatcher
+	ClientCert *clientCertMatcher
+	Body       *bodyMatcher
+	Log        bool
+	Scenario   config.Scenario
+
+	// latency delays the responses, and rand is the random source of the processor
+	latency *latency
+	rand    *random
+
+	// fault injects failures instead of the responses, and throttle slows them down, when configured
+	fault    *fault
+	throttle *throttle
+
+	// validator checks requests against an OpenAPI spec. Invalid requests are rejected when
+	// rejectInvalid is set, otherwise they are only logged and recorded in the journal
+	validator     *openapi.Validator
+	rejectInvalid bool
 }
 
 // NewFromConfig creates a new RequestProcessor from a Config struct
 func NewFromConfig(c config.Config) (Processor, error) {
-	var proc processor
-	for _, service := range c.Services {
-		base, err := createBaseParser(service.Parser)
+	proc := &processor{
+		scenarios: newScenarioStore(),
+		journal:   newJournal(c.JournalSize),
+		rand:      newRandom(c.Seed),
+		done:      make(chan struct{}),
+	}
+
+	parsers, err := proc.createServices(c.Services)
+	if err != nil {
+		return nil, err
+	}
+	proc.setParsers(parsers)
+
+	return proc, nil
+}
+
+// Reload replaces all parsers with the ones built from a new config. If any parser fails to be
+// created, the current parsers are kept. Runtime state (scenarios and journal) is preserved, and the
+// random source is seeded again when the config has a seed
+func (rp *processor) Reload(c config.Config) error {
+	parsers, err := rp.createServices(c.Services)
+	if err != nil {
+		return err
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	rp.setParsers(parsers)
+	if c.Seed != 0 {
+		rp.rand.seed(c.Seed)
+	}
+
+	return nil
+}
+
+// createServices creates the parsers of the services. When one of them fails, the parsers already
+// created are stopped, since they are not used
+func (rp *processor) createServices(services []config.Service) ([]parser, error) {
+	var parsers []parser
+	for _, service := range services {
+		created, err := rp.createParsers(service.Parser)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing base config: %w", err)
+			stopRemoved(parsers, nil)
+			return nil, err
 		}
+		parsers = append(parsers, created...)
+	}
 
-		switch service.Parser.ConfigType {
-		case "pass":
-			passParser, err := createPassParser(base, service.Parser)
-			if err != nil {
-				return nil, fmt.Errorf("error while creating pass parser: %w", err)
-			}
-			proc.Parsers = append(proc.Parsers, passParser)
-		case "mock":
-			mparser := mockParser{baseParser: base}
-			baseResp := baseResponse{
-				Status:  service.Parser.Response.Status,
-				Headers: service.Parser.Response.Headers,
-			}
+	return parsers, nil
+}
 
-			resp, err := parseMockResponseConfig(service.Parser.Response, baseResp)
-			if err != nil {
-				return nil, fmt.Errorf("error while parsing response: %w", err)
-			}
-			mparser.Response = resp
-			proc.Parsers = append(proc.Parsers, &mparser)
-		default:
-			return nil, fmt.Errorf("bad value for config-type %s", service.Parser.ConfigType)
+// createParsers creates the parsers of a service. It is a single parser, except for openapi services
+// which have a parser for each operation of the spec
+func (rp *processor) createParsers(conf config.Parser) ([]parser, error) {
+	if conf.ConfigType != "openapi" {
+		p, err := rp.createParser(conf)
+		if err != nil {
+			return nil, err
 		}
+		return []parser{p}, nil
+	}
+
+	spec, err := openapi.Load(conf.OpenAPI.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	basePath := conf.OpenAPI.BasePath
+	if basePath == "" {
+		basePath = spec.ServersBasePath()
+	}
+
+	if conf.Validation.Mode != "" && conf.Validation.Spec == "" {
+		conf.Validation.Spec = conf.OpenAPI.Spec
+		conf.Validation.BasePath = basePath
 	}
 
-	return &proc, nil
+	services, err := spec.Services(conf, basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	parsers := make([]parser, 0, len(services))
+	for _, service := range services {
+		p, err := rp.createParser(service.Parser)
+		if err != nil {
+			stopRemoved(parsers, nil)
+			return nil, fmt.Errorf("error creating parser %s: %w", service.Parser.Name, err)
+		}
+		parsers = append(parsers, p)
+	}
+
+	return parsers, nil
+}
+
+// createParser creates a parser from its config
+func (rp *processor) createParser(conf config.Parser) (parser, error) {
+	base, err := createBaseParser(conf, rp.rand)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing base config: %w", err)
+	}
+
+	if base.Scenario.Name != "" {
+		rp.scenarios.register(base.Scenario.Name)
+	}
+
+	switch conf.ConfigType {
+	case "pass":
+		passParser, err := createPassParser(base, conf)
+		if err != nil {
+			return nil, fmt.Errorf("error while creating pass parser: %w", err)
+		}
+		return passParser, nil
+	case "replay":
+		replayParser, err := createReplayParser(base, conf)
+		if err != nil {
+			return nil, fmt.Errorf("error while creating replay parser: %w", err)
+		}
+		return replayParser, nil
+	case "mock":
+		mparser := mockParser{baseParser: base}
+		baseResp := baseResponse{
+			Status:            conf.Response.Status,
+			Headers:           conf.Response.Headers,
+			MultiValueHeaders: conf.Response.MultiValueHeaders,
+		}
+
+		resp, err := parseMockResponseConfig(conf.Response, baseResp, base.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing response: %w", err)
+		}
+		mparser.Response = resp
+		return &mparser, nil
+	default:
+		return nil, fmt.Errorf("bad value for config-type %s", conf.ConfigType)
+	}
 }
 
-func createBaseParser(conf config.Parser) (baseParser, error) {
+func createBaseParser(conf config.Parser, rnd *random) (baseParser, error) {
 	base := baseParser{
-		Headers: conf.Headers,
-		Log:     conf.Log,
-		Methods: conf.Methods,
-		Pattern: conf.Pattern,
+		rand:     rnd,
+		Config:   conf,
+		Headers:  conf.Headers,
+		Log:      conf.Log,
+		Methods:  conf.Methods,
+		Pattern:  conf.Pattern,
+		Scenario: conf.Scenario,
 	}
 
-	if conf.Delay.Min != "" && conf.Delay.Max != "" {
-		min, err := time.ParseDuration(conf.Delay.Min)
+	pattern, err := regexp.Compile(conf.Pattern)
+	if err != nil {
+		return baseParser{}, fmt.Errorf("error parsing pattern %s: %w", conf.Pattern, err)
+	}
+	base.pattern = pattern
+
+	query, err := createQueryMatchers(conf.Query)
+	if err != nil {
+		return baseParser{}, err
+	}
+	base.Query = query
+
+	base.ClientCert, err = createClientCertMatcher(conf.ClientCert)
+	if err != nil {
+		return baseParser{}, err
+	}
+
+	body, err := createBodyMatcher(conf.Body)
+	if err != nil {
+		return baseParser{}, err
+	}
+	base.Body = body
+
+	if conf.Validation.Spec != "" {
+		spec, err := openapi.Load(conf.Validation.Spec)
 		if err != nil {
-			return baseParser{}, fmt.Errorf("error parsing min delay: %w", err)
+			return baseParser{}, err
 		}
-		max, err := time.ParseDuration(conf.Delay.Max)
+
+		basePath := conf.Validation.BasePath
+		if basePath == "" {
+			basePath = spec.ServersBasePath()
+		}
+
+		base.validator, err = openapi.NewValidator(spec, basePath)
 		if err != nil {
-			return baseParser{}, fmt.Errorf("error parsing max delay: %w", err)
+			return baseParser{}, err
 		}
-		base.MaxDelay = max
-		base.MinDelay = min
+		base.rejectInvalid = conf.Validation.Mode != "log"
+	}
+
+	base.latency, err = newLatency(conf.Delay)
+	if err != nil {
+		return baseParser{}, err
+	}
+
+	base.fault, err = newFault(conf.Fault, rnd)
+	if err != nil {
+		return baseParser{}, err
+	}
+
+	base.throttle, err = newThrottle(conf.Throttle)
+	if err != nil {
+		return baseParser{}, err
 	}
 
 	return base, nil
 }
 
-func parseMockResponseConfig(conf config.Response, base baseResponse) (response, error) {
+func parseMockResponseConfig(conf config.Response, base baseResponse, pattern *regexp.Regexp) (response, error) {
+	body := conf.Body
+	if conf.BodyFile != "" && (conf.BodyType == "fixed" || conf.BodyType == "template" || conf.BodyType == "script") {
+		var err error
+		body, err = readBodyFile(conf.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open body response file: %w", err)
+		}
+	}
+
 	switch conf.BodyType {
 
 	case "fixed":
-		body := conf.Body
-		if conf.BodyFile != "" {
-			var err error
-			body, err = readBodyFile(conf.BodyFile)
-			if err != nil {
-				return nil, fmt.Errorf("failed to open body response file: %w", err)
-			}
-		}
 
 		return &responseFixed{
 			baseResponse: base,
@@ -188,11 +612,54 @@ func parseMockResponseConfig(conf config.Response, base baseResponse) (response,
 				SourceFolder: conf.MagicHeaderFolder,
 			},
 		}, nil
+	case "template":
+		resp, err := newResponseTemplate(base, pattern, body, conf.StatusTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("error processing template response: %w", err)
+		}
+
+		return resp, nil
+	case "script":
+		name := conf.BodyFile
+		if name == "" {
+			name = "script"
+		}
+
+		resp, err := newResponseScript(base, pattern, name, body)
+		if err != nil {
+			return nil, fmt.Errorf("error loading script response: %w", err)
+		}
+
+		return resp, nil
+	case "exec":
+		plugin, err := newExecPlugin(conf.Exec)
+		if err != nil {
+			return nil, fmt.Errorf("error loading exec response: %w", err)
+		}
+
+		return &responseExec{
+			baseResponse: base,
+			pattern:      pattern,
+			plugin:       plugin,
+		}, nil
 	case "echo":
 		return &responseEcho{
 			baseResponse: base,
 		}, nil
 	case "runnable":
+		if isWasm(conf.ResponseLib) {
+			plugin, err := newWasmPlugin(conf.ResponseLib, conf.ResponseSymbol)
+			if err != nil {
+				return nil, fmt.Errorf("error loading wasm response: %w", err)
+			}
+
+			return &responseExec{
+				baseResponse: base,
+				pattern:      pattern,
+				plugin:       plugin,
+			}, nil
+		}
+
 		runnable, err := loadRunnableFunc(conf.ResponseLib, conf.ResponseSymbol)
 		if err != nil {
 			return nil, fmt.Errorf("error processing transform method: %w", err)
@@ -216,7 +683,7 @@ func readBodyFile(src string) (string, error) {
 	return string(b), nil
 }
 
-func containsMethod(slice []string, item string) bool {
+func containsString(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
 			return true
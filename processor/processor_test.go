@@ -2,21 +2,37 @@ package processor_test
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/rodrigo-kayala/mirage-mocker/certs"
 	"github.com/rodrigo-kayala/mirage-mocker/config"
+	"github.com/rodrigo-kayala/mirage-mocker/openapi"
 	"github.com/rodrigo-kayala/mirage-mocker/processor"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
 )
 
 func buildTestConfig() config.Config {
+	absent := false
+
 	return config.Config{
 		Services: []config.Service{
 			{
@@ -110,6 +126,159 @@ func buildTestConfig() config.Config {
 					},
 				},
 			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/template/(\\w+)/(?P<item>\\w+)$",
+					Methods:    []string{"GET", "POST"},
+					ConfigType: "mock",
+					Log:        true,
+					Response: config.Response{
+						Headers: map[string]string{
+							"content-type": "application/json",
+							"x-item":       "{{.Vars.item}}",
+						},
+						Status: map[string]int{
+							"GET":  200,
+							"POST": 201,
+						},
+						StatusTemplate: "{{with .Query.Get \"status\"}}{{.}}{{end}}",
+						BodyType:       "template",
+						Body:           "{\"group\": \"{{index .Params 1}}\", \"q\": \"{{.Query.Get \"q\"}}\", \"name\": \"{{with .JSON.name}}{{.}}{{end}}\", \"c\": \"{{.Cookies.session}}\"}",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/script/users/(?P<id>\\d+)$",
+					Methods:    []string{"GET", "POST"},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"GET":  200,
+							"POST": 200,
+						},
+						BodyType: "script",
+						BodyFile: "testdata/script.star",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/search$",
+					Methods:    []string{"GET"},
+					Query:      map[string]config.Query{"type": {Equals: "a"}, "debug": {Present: &absent}},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"GET": 200,
+						},
+						BodyType: "fixed",
+						Body:     "type a",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/search$",
+					Methods:    []string{"GET"},
+					Query:      map[string]config.Query{"type": {Matches: "^b[0-9]+$"}},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"GET": 200,
+						},
+						BodyType: "fixed",
+						Body:     "type b",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/search$",
+					Methods:    []string{"GET"},
+					Query:      map[string]config.Query{"tag": {Values: []string{"x", "y"}}},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"GET": 200,
+						},
+						BodyType: "fixed",
+						Body:     "tags x and y",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern: "^/mock/orders$",
+					Methods: []string{"POST"},
+					Body: config.BodyMatcher{
+						JSONPath: []config.JSONPath{
+							{Path: "$.items[*].sku", Equals: "A1"},
+							{Path: "$.coupon", Exists: &absent},
+						},
+					},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"POST": 200,
+						},
+						BodyType: "fixed",
+						Body:     "sku a1",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/orders$",
+					Methods:    []string{"POST"},
+					Body:       config.BodyMatcher{Contains: `{"customer": {"tier": "gold"}}`},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"POST": 200,
+						},
+						BodyType: "fixed",
+						Body:     "gold",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/orders$",
+					Methods:    []string{"POST"},
+					Body:       config.BodyMatcher{Equals: `{"type": "echo", "n": 1}`},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"POST": 200,
+						},
+						BodyType: "echo",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/orders$",
+					Methods:    []string{"POST"},
+					Body:       config.BodyMatcher{JSONPath: []config.JSONPath{{Path: "$['id']", Matches: "^[0-9]+$"}}},
+					ConfigType: "mock",
+					Response: config.Response{
+						Headers: map[string]string{"content-type": "text/plain"},
+						Status: map[string]int{
+							"POST": 200,
+						},
+						BodyType: "fixed",
+						Body:     "numeric id",
+					},
+				},
+			},
 			{
 				Parser: config.Parser{
 					Pattern:    "/mock/runnable.*",
@@ -126,10 +295,118 @@ func buildTestConfig() config.Config {
 					},
 				},
 			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/wasm/(?P<name>\\w+)$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Response: config.Response{
+						Status: map[string]int{
+							"GET": 200,
+						},
+						BodyType:       "runnable",
+						ResponseLib:    "testdata/wasm/plugin.wasm",
+						ResponseSymbol: "Greet",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/delay/fixed$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Delay:      config.Delay{Distribution: "fixed", Value: "100ms"},
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "mock",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/delay/normal$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					// bounded by max
+					Delay: config.Delay{Distribution: "normal", Mean: "1s", StdDev: "10ms", Max: "20ms"},
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "mock",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/delay/percentiles$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Delay:      config.Delay{Distribution: "percentiles", P50: "30ms", P99: "30ms"},
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "mock",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/client-cert$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					ClientCert: config.ClientCert{CommonName: "alice"},
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "alice",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/client-cert$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					ClientCert: config.ClientCert{Subject: "O=Partner"},
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "partner",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock/client-cert$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "anonymous",
+					},
+				},
+			},
 		},
 	}
 }
 
+// serve sends a request to the handler and returns the recorded response
+func serve(t *testing.T, handler http.Handler, method string, url string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
 func Test_processor_Process(t *testing.T) {
 	os.Setenv("MIRAGE_MOCKER_TEST_VAR", "mirage-mocker")
 
@@ -139,12 +416,15 @@ func Test_processor_Process(t *testing.T) {
 		endpoint string
 		body     io.Reader
 		headers  map[string]string
+		// subject of the client certificate, when set
+		cert *pkix.Name
 	}
 	type out struct {
 		status          int
 		body            string
 		headers         map[string]string
 		minimumDuration time.Duration
+		maximumDuration time.Duration
 	}
 	type test struct {
 		name    string
@@ -271,160 +551,2209 @@ func Test_processor_Process(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name: "mock with magic header file value - fallback",
+			name: "mock with wasm runnable response",
 			args: args{
 				config:   buildTestConfig(),
 				method:   "GET",
-				endpoint: "/mock/magicheader/file/something",
-				body:     nil,
+				endpoint: "/mock/wasm/mirage",
+			},
+			out: out{
+				status:  200,
+				body:    "hello mirage",
+				headers: map[string]string{"content-type": "text/plain"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with wasm runnable response - missing symbol",
+			args: args{
+				config: config.Config{
+					Services: []config.Service{
+						{
+							Parser: config.Parser{
+								Pattern:    "/mock/wasm",
+								Methods:    []string{"GET"},
+								ConfigType: "mock",
+								Response: config.Response{
+									Status:         map[string]int{"GET": 200},
+									BodyType:       "runnable",
+									ResponseLib:    "testdata/wasm/plugin.wasm",
+									ResponseSymbol: "Missing",
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mock with fixed delay",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/delay/fixed",
 			},
 			out: out{
 				status:          200,
-				body:            "{\"some\": \"fallback\"}",
-				headers:         map[string]string{"content-type": "application/json"},
-				minimumDuration: 0,
+				body:            "mock",
+				minimumDuration: 100 * time.Millisecond,
 			},
 			wantErr: false,
 		},
 		{
-			name: "mock with magic header file value - response1",
+			name: "mock with normal delay bounded by max",
 			args: args{
 				config:   buildTestConfig(),
 				method:   "GET",
-				endpoint: "/mock/magicheader/file/something",
-				headers:  map[string]string{"X-MOCK-FILE": "response1.json"},
-				body:     nil,
+				endpoint: "/mock/delay/normal",
 			},
 			out: out{
 				status:          200,
-				body:            "{\"some\": \"response1\"}",
-				headers:         map[string]string{"content-type": "application/json"},
-				minimumDuration: 0,
+				body:            "mock",
+				minimumDuration: 20 * time.Millisecond,
+				maximumDuration: 500 * time.Millisecond,
 			},
 			wantErr: false,
 		},
 		{
-			name: "mock with magic header file value - response2",
+			name: "mock with percentiles delay",
 			args: args{
 				config:   buildTestConfig(),
 				method:   "GET",
-				endpoint: "/mock/magicheader/file/something",
-				headers:  map[string]string{"X-MOCK-FILE": "response2.json"},
-				body:     nil,
+				endpoint: "/mock/delay/percentiles",
 			},
 			out: out{
 				status:          200,
-				body:            "{\"some\": \"response2\"}",
-				headers:         map[string]string{"content-type": "application/json"},
-				minimumDuration: 0,
+				body:            "mock",
+				minimumDuration: 30 * time.Millisecond,
 			},
 			wantErr: false,
 		},
 		{
-			name: "mock with magic header file value - not found",
+			name: "client certificate - common name",
 			args: args{
 				config:   buildTestConfig(),
 				method:   "GET",
-				endpoint: "/mock/magicheader/file/something",
-				headers:  map[string]string{"X-MOCK-FILE": "response3.json"},
-				body:     nil,
+				endpoint: "/mock/client-cert",
+				cert:     &pkix.Name{CommonName: "alice", Organization: []string{"Partner"}},
 			},
 			out: out{
-				status:          404,
-				body:            "file not found response3.json",
-				headers:         map[string]string{"content-type": "text/plain"},
-				minimumDuration: 0,
+				status: 200,
+				body:   "alice",
 			},
 			wantErr: false,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			start := time.Now()
-			assert := assert.New(t)
-
-			p, err := processor.NewFromConfig(tt.args.config)
-			if tt.wantErr {
-				assert.Error(err)
-				return
-			}
-			assert.NoError(err)
-
-			req, err := http.NewRequest(tt.args.method, tt.args.endpoint, tt.args.body)
-			assert.NoError(err)
-
-			for k, v := range tt.args.headers {
-				req.Header.Add(k, v)
-			}
-
-			rr := httptest.NewRecorder()
-			handler := http.HandlerFunc(p.Process)
-
-			handler.ServeHTTP(rr, req)
-			assert.Equal(tt.out.status, rr.Code)
-			assert.Equal(tt.out.body, rr.Body.String())
-			for k, v := range tt.out.headers {
-				assert.Equal(rr.Header().Get(k), v)
-			}
+		{
+			name: "client certificate - subject",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/client-cert",
+				cert:     &pkix.Name{CommonName: "bob", Organization: []string{"Partner"}},
+			},
+			out: out{
+				status: 200,
+				body:   "partner",
+			},
+			wantErr: false,
+		},
+		{
+			name: "client certificate - not matched",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/client-cert",
+				cert:     &pkix.Name{CommonName: "carol"},
+			},
+			out: out{
+				status: 200,
+				body:   "anonymous",
+			},
+			wantErr: false,
+		},
+		{
+			name: "client certificate - none",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/client-cert",
+			},
+			out: out{
+				status: 200,
+				body:   "anonymous",
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with template response",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/template/users/42?q=search",
+				headers:  map[string]string{"Cookie": "session=abc"},
+			},
+			out: out{
+				status:          200,
+				body:            "{\"group\": \"users\", \"q\": \"search\", \"name\": \"\", \"c\": \"abc\"}",
+				headers:         map[string]string{"content-type": "application/json", "x-item": "42"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with template response - json body and status",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/template/users/7?status=202",
+				body:     strings.NewReader("{\"name\": \"mirage\"}"),
+			},
+			out: out{
+				status:          202,
+				body:            "{\"group\": \"users\", \"q\": \"\", \"name\": \"mirage\", \"c\": \"\"}",
+				headers:         map[string]string{"content-type": "application/json", "x-item": "7"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with template response - out of range status",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/template/users/7?status=42",
+			},
+			out: out{
+				status: 500,
+				body:   "bad status 42 for GET: the template must render a status from 100 to 999 or the status for the method must be set",
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with template response - empty status without a status for the method",
+			args: args{
+				config: config.Config{
+					Services: []config.Service{
+						{
+							Parser: config.Parser{
+								Pattern:    "/mock/template",
+								Methods:    []string{"GET"},
+								ConfigType: "mock",
+								Response: config.Response{
+									StatusTemplate: "{{with .Query.Get \"status\"}}{{.}}{{end}}",
+									BodyType:       "template",
+									Body:           "{{.Path}}",
+								},
+							},
+						},
+					},
+				},
+				method:   "GET",
+				endpoint: "/mock/template",
+			},
+			out: out{
+				status: 500,
+				body:   "bad status 0 for GET: the template must render a status from 100 to 999 or the status for the method must be set",
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with template response - syntax error",
+			args: args{
+				config: config.Config{
+					Services: []config.Service{
+						{
+							Parser: config.Parser{
+								Pattern:    "/mock/template",
+								Methods:    []string{"GET"},
+								ConfigType: "mock",
+								Response: config.Response{
+									Status:   map[string]int{"GET": 200},
+									BodyType: "template",
+									Body:     "{{.Path",
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mock with script response",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/script/users/42?source=test",
+			},
+			out: out{
+				status:          200,
+				body:            "user 42 from test",
+				headers:         map[string]string{"content-type": "text/plain", "x-user": "42"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with script response - json body",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/script/users/7",
+				body:     strings.NewReader("{\"name\": \"mirage\", \"tags\": [\"a\", \"b\"]}"),
+			},
+			out: out{
+				status:          201,
+				body:            "{\"id\":7,\"name\":\"mirage\",\"tags\":[\"A\",\"B\"]}",
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with script response - missing handle function",
+			args: args{
+				config: config.Config{
+					Services: []config.Service{
+						{
+							Parser: config.Parser{
+								Pattern:    "/mock/script",
+								Methods:    []string{"GET"},
+								ConfigType: "mock",
+								Response: config.Response{
+									Status:   map[string]int{"GET": 200},
+									BodyType: "script",
+									Body:     "def respond(req, resp):\n    pass\n",
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mock with script response - too many steps",
+			args: args{
+				config: config.Config{
+					Services: []config.Service{
+						{
+							Parser: config.Parser{
+								Pattern:    "/mock/script",
+								Methods:    []string{"GET"},
+								ConfigType: "mock",
+								Response: config.Response{
+									Status:   map[string]int{"GET": 200},
+									BodyType: "script",
+									Body:     "def handle(req, resp):\n    for i in range(1 << 40):\n        pass\n",
+								},
+							},
+						},
+					},
+				},
+				method:   "GET",
+				endpoint: "/mock/script",
+			},
+			out: out{
+				status: 500,
+				body:   "error running script: Traceback (most recent call last):\n  script:2:5: in handle\nError: Starlark computation cancelled: too many steps",
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with script response - out of range status",
+			args: args{
+				config: config.Config{
+					Services: []config.Service{
+						{
+							Parser: config.Parser{
+								Pattern:    "/mock/script",
+								Methods:    []string{"GET"},
+								ConfigType: "mock",
+								Response: config.Response{
+									Status:   map[string]int{"GET": 200},
+									BodyType: "script",
+									Body:     "def handle(req, resp):\n    resp.status = 1000\n",
+								},
+							},
+						},
+					},
+				},
+				method:   "GET",
+				endpoint: "/mock/script",
+			},
+			out: out{
+				status: 500,
+				body:   "error running script: Traceback (most recent call last):\n  script:2:9: in handle\nError: response.status must be from 100 to 999, got 1000",
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with script response - no status",
+			args: args{
+				config: config.Config{
+					Services: []config.Service{
+						{
+							Parser: config.Parser{
+								Pattern:    "/mock/script",
+								Methods:    []string{"GET"},
+								ConfigType: "mock",
+								Response: config.Response{
+									BodyType: "script",
+									Body:     "def handle(req, resp):\n    resp.body = \"ok\"\n",
+								},
+							},
+						},
+					},
+				},
+				method:   "GET",
+				endpoint: "/mock/script",
+			},
+			out: out{
+				status: 500,
+				body:   "error running script: bad status 0, response.status must be set",
+			},
+			wantErr: false,
+		},
+		{
+			name: "query matching - exact value",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/search?type=a",
+			},
+			out: out{
+				status:          200,
+				body:            "type a",
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "query matching - absent param",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/search?type=a&debug=1",
+			},
+			out: out{
+				status:          404,
+				body:            "error processing request: no match found for request",
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "query matching - regex",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/search?type=b12",
+			},
+			out: out{
+				status:          200,
+				body:            "type b",
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "query matching - multi-valued",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/search?tag=y&tag=z&tag=x",
+			},
+			out: out{
+				status:          200,
+				body:            "tags x and y",
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "query matching - missing value",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/search?tag=y",
+			},
+			out: out{
+				status:          404,
+				body:            "error processing request: no match found for request",
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "body matching - json path",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/orders",
+				body:     strings.NewReader(`{"items": [{"sku": "B2"}, {"sku": "A1"}]}`),
+			},
+			out: out{
+				status:          200,
+				body:            `sku a1`,
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "body matching - json path exists",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/orders",
+				body:     strings.NewReader(`{"items": [{"sku": "A1"}], "coupon": "X", "id": "a"}`),
+			},
+			out: out{
+				status:          404,
+				body:            `error processing request: no match found for request`,
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "body matching - contains",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/orders",
+				body:     strings.NewReader(`{"id": 1, "customer": {"name": "x", "tier": "gold"}}`),
+			},
+			out: out{
+				status:          200,
+				body:            `gold`,
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "body matching - equals keeps body for echo",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/orders",
+				body:     strings.NewReader(`{"n": 1, "type": "echo"}`),
+			},
+			out: out{
+				status:          200,
+				body:            `{"n": 1, "type": "echo"}`,
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "body matching - json path regex",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/orders",
+				body:     strings.NewReader(`{"id": 123}`),
+			},
+			out: out{
+				status:          200,
+				body:            `numeric id`,
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "body matching - invalid json",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "POST",
+				endpoint: "/mock/orders",
+				body:     strings.NewReader(`not json`),
+			},
+			out: out{
+				status:          404,
+				body:            `error processing request: no match found for request`,
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with magic header file value - fallback",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/magicheader/file/something",
+				body:     nil,
+			},
+			out: out{
+				status:          200,
+				body:            "{\"some\": \"fallback\"}",
+				headers:         map[string]string{"content-type": "application/json"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with magic header file value - response1",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/magicheader/file/something",
+				headers:  map[string]string{"X-MOCK-FILE": "response1.json"},
+				body:     nil,
+			},
+			out: out{
+				status:          200,
+				body:            "{\"some\": \"response1\"}",
+				headers:         map[string]string{"content-type": "application/json"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with magic header file value - response2",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/magicheader/file/something",
+				headers:  map[string]string{"X-MOCK-FILE": "response2.json"},
+				body:     nil,
+			},
+			out: out{
+				status:          200,
+				body:            "{\"some\": \"response2\"}",
+				headers:         map[string]string{"content-type": "application/json"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+		{
+			name: "mock with magic header file value - not found",
+			args: args{
+				config:   buildTestConfig(),
+				method:   "GET",
+				endpoint: "/mock/magicheader/file/something",
+				headers:  map[string]string{"X-MOCK-FILE": "response3.json"},
+				body:     nil,
+			},
+			out: out{
+				status:          404,
+				body:            "file not found response3.json",
+				headers:         map[string]string{"content-type": "text/plain"},
+				minimumDuration: 0,
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			assert := assert.New(t)
+
+			p, err := processor.NewFromConfig(tt.args.config)
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+
+			req, err := http.NewRequest(tt.args.method, tt.args.endpoint, tt.args.body)
+			assert.NoError(err)
+
+			for k, v := range tt.args.headers {
+				req.Header.Add(k, v)
+			}
+			if tt.args.cert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: *tt.args.cert}}}
+			}
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(p.Process)
+
+			handler.ServeHTTP(rr, req)
+			assert.Equal(tt.out.status, rr.Code)
+			assert.Equal(tt.out.body, rr.Body.String())
+			for k, v := range tt.out.headers {
+				assert.Equal(rr.Header().Get(k), v)
+			}
 			elapsed := time.Since(start)
 
-			assert.LessOrEqual(tt.out.minimumDuration, elapsed)
-		})
+			assert.LessOrEqual(tt.out.minimumDuration, elapsed)
+			if tt.out.maximumDuration > 0 {
+				assert.Less(elapsed, tt.out.maximumDuration)
+			}
+		})
+	}
+}
+
+func Test_processor_Process__pass(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("MIRAGE_MOCKER_TEST_VAR", "mirage-mocker")
+
+	inBody := map[string]string{"some": "value"}
+	inJSON, err := json.Marshal(inBody)
+	assert.NoError(err)
+
+	outBody := map[string]string{"other": "value"}
+	outJSON, err := json.Marshal(outBody)
+	assert.NoError(err)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(r.Header.Get("OTHER_HEADER"), "otherHeader")
+		assert.Equal(r.Header.Get("MIRAGE_MOCKER_TEST_VAR"), "mirage-mocker")
+		assert.Equal(r.URL.Path, "/pass")
+
+		body := r.Body
+		defer body.Close()
+		bodyBytes, err := io.ReadAll(body)
+		assert.EqualValues(inJSON, bodyBytes)
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(200)
+
+		if err != nil {
+			assert.NoError(err)
+		}
+		_, _ = w.Write(outJSON)
+	}))
+
+	backend.Start()
+	defer backend.Close()
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern: "/test/pass.*",
+					Rewrites: []config.Rewrite{
+						{
+							Source: "/test(/.*)",
+							Target: "$1",
+						},
+					},
+					Methods:         []string{"POST"},
+					ConfigType:      "pass",
+					Log:             true,
+					TransformLib:    "testdata/transform/transform.so",
+					TransformSymbol: "AddHeader",
+					PassBaseURI:     backend.URL,
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	req, err := http.NewRequest("POST", "/test/pass?vname=MIRAGE_MOCKER_TEST_VAR", bytes.NewReader(inJSON))
+	assert.NoError(err)
+	req.Header.Add("OTHER_HEADER", "otherHeader")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(p.Process)
+
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal(string(outJSON), rr.Body.String())
+	assert.Equal("application/json", rr.Header().Get("Content-Type"))
+
+}
+
+func Test_processor_Process__passModifyResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Server", "backend")
+		_, _ = w.Write([]byte(`{"id": 1}`))
+	}))
+	defer backend.Close()
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:                 "^/pass$",
+					Methods:                 []string{"GET"},
+					ConfigType:              "pass",
+					PassBaseURI:             backend.URL,
+					TransformLib:            "testdata/transform/transform.so",
+					TransformResponseSymbol: "WrapResponse",
+					ModifyResponse: config.ModifyResponse{
+						Status:        http.StatusAccepted,
+						SetHeaders:    map[string]string{"X-Mocked": "true"},
+						RemoveHeaders: []string{"Server"},
+					},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	req, err := http.NewRequest("GET", "/pass", nil)
+	assert.NoError(err)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+
+	assert.Equal(http.StatusAccepted, rr.Code)
+	assert.JSONEq(`{"data": {"id": 1}}`, rr.Body.String())
+	assert.Equal("true", rr.Header().Get("X-Mocked"))
+	assert.Empty(rr.Header().Get("Server"))
+	assert.Equal("application/json", rr.Header().Get("Content-Type"))
+}
+
+func Test_processor_Process__passModifyRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"host":    r.Host,
+			"query":   r.URL.RawQuery,
+			"user":    r.Header.Get("X-User"),
+			"tags":    r.Header.Values("X-Tag"),
+			"session": r.Header.Get("X-Session"),
+		})
+	}))
+	defer backend.Close()
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:     "^/users/(?P<id>[0-9]+)$",
+					Methods:     []string{"GET"},
+					ConfigType:  "pass",
+					PassBaseURI: backend.URL,
+					ModifyRequest: config.ModifyRequest{
+						Host:          "api.example.com",
+						SetHeaders:    map[string]string{"X-User": "{{ .Vars.id }}"},
+						AddHeaders:    map[string]string{"X-Tag": `{{ upper (index .Headers "X-Tag" 0) }}`},
+						RemoveHeaders: []string{"X-Session"},
+						SetQuery:      map[string]string{"userId": "{{ .Vars.id }}", "sort": "name"},
+						RemoveQuery:   []string{"debug"},
+					},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	req, err := http.NewRequest("GET", "/users/42?debug=true&sort=id", nil)
+	assert.NoError(err)
+	req.Header.Set("X-Tag", "mock")
+	req.Header.Set("X-Session", "secret")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.JSONEq(`{
+		"host": "api.example.com",
+		"query": "sort=name&userId=42",
+		"user": "42",
+		"tags": ["mock", "MOCK"],
+		"session": ""
+	}`, rr.Body.String())
+}
+
+func Test_processor_Process__passWasmTransform(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Wasm")))
+	}))
+	defer backend.Close()
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:         "/test/pass.*",
+					Rewrites:        []config.Rewrite{{Source: "/test(/.*)", Target: "$1"}},
+					Methods:         []string{"POST"},
+					ConfigType:      "pass",
+					TransformLib:    "testdata/wasm/plugin.wasm",
+					TransformSymbol: "AddHeader",
+					PassBaseURI:     backend.URL,
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	// the module instance is reused by the next requests
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("POST", "/test/pass", strings.NewReader(`{"n": 1}`))
+		assert.NoError(err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+
+		assert.Equal(http.StatusOK, rr.Code)
+		assert.Equal("POST /test/pass", rr.Body.String())
+	}
+}
+
+func Test_processor_Process__passFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(300 * time.Millisecond)
+		default:
+			_, _ = w.Write([]byte("upstream"))
+		}
+	}))
+	defer backend.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	fallback := config.Fallback{
+		OnStatus: []int{http.StatusServiceUnavailable},
+		Timeout:  "100ms",
+		Response: config.Response{
+			Headers:  map[string]string{"x-fallback": "true"},
+			Status:   map[string]int{"POST": 200},
+			BodyType: "echo",
+		},
+	}
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:     "^/down$",
+					Methods:     []string{"POST"},
+					ConfigType:  "pass",
+					PassBaseURI: closed.URL,
+					Fallback:    fallback,
+				},
+			},
+			{
+				// the fallback is rendered from the client request, not the rewritten upstream request
+				Parser: config.Parser{
+					Pattern:       "^/users/(?P<id>\\w+)$",
+					Methods:       []string{"POST"},
+					ConfigType:    "pass",
+					PassBaseURI:   backend.URL,
+					Rewrites:      []config.Rewrite{{Source: "^/users/(.*)$", Target: "/unavailable"}},
+					ModifyRequest: config.ModifyRequest{SetHeaders: map[string]string{"X-Injected": "true"}},
+					Fallback: config.Fallback{
+						OnStatus: []int{http.StatusServiceUnavailable},
+						Response: config.Response{
+							Status:   map[string]int{"POST": 200},
+							BodyType: "template",
+							Body:     `{{.Path}} {{.Vars.id}} {{.Body}} [{{.Headers.Get "X-Injected"}}]`,
+						},
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:     ".*",
+					Methods:     []string{"POST"},
+					ConfigType:  "pass",
+					PassBaseURI: backend.URL,
+					Fallback:    fallback,
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	tests := []struct {
+		endpoint string
+		body     string
+		fallback string
+	}{
+		{endpoint: "/ok", body: "upstream"},
+		{endpoint: "/down", body: "request body", fallback: "true"},
+		{endpoint: "/unavailable", body: "request body", fallback: "true"},
+		{endpoint: "/slow", body: "request body", fallback: "true"},
+		{endpoint: "/users/42", body: "/users/42 42 request body []"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", tt.endpoint, strings.NewReader("request body"))
+		assert.NoError(err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+
+		assert.Equal(http.StatusOK, rr.Code, tt.endpoint)
+		assert.Equal(tt.body, rr.Body.String(), tt.endpoint)
+		assert.Equal(tt.fallback, rr.Header().Get("x-fallback"), tt.endpoint)
+	}
+}
+
+func Test_processor_Process__passTransport(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	ca, err := certs.LoadOrCreateCA(dir)
+	assert.NoError(err)
+
+	serverCert, err := ca.Issue("127.0.0.1", []string{"127.0.0.1"})
+	assert.NoError(err)
+
+	// client certificate of the mocker, for the mTLS upstream
+	clientCert, err := ca.Issue("mirage", nil)
+	assert.NoError(err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(clientCert.PrivateKey)
+	assert.NoError(err)
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	assert.NoError(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert.Certificate[0]}), 0600))
+	assert.NoError(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Cert)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.TLS.PeerCertificates[0].Subject.CommonName, r.Proto)
+	}))
+	backend.EnableHTTP2 = true
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	// open connections to the backend, to check they are closed with their parsers
+	var open int32
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&open, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt32(&open, -1)
+		}
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	disabled := false
+	caFile := filepath.Join(dir, certs.CAFile)
+	tests := []struct {
+		endpoint  string
+		transport config.Transport
+		status    int
+		body      string
+	}{
+		{
+			endpoint:  "/mtls",
+			transport: config.Transport{CA: caFile, Cert: certFile, Key: keyFile, DialTimeout: "1s"},
+			status:    http.StatusOK,
+			body:      "mirage HTTP/2.0",
+		},
+		{
+			endpoint:  "/insecure",
+			transport: config.Transport{InsecureSkipVerify: true, Cert: certFile, Key: keyFile},
+			status:    http.StatusOK,
+			body:      "mirage HTTP/2.0",
+		},
+		{
+			endpoint:  "/http1",
+			transport: config.Transport{CA: caFile, Cert: certFile, Key: keyFile, HTTP2: &disabled},
+			status:    http.StatusOK,
+			body:      "mirage HTTP/1.1",
+		},
+		{
+			endpoint:  "/no-cert",
+			transport: config.Transport{CA: caFile},
+			status:    http.StatusBadGateway,
+		},
+		{
+			endpoint:  "/untrusted",
+			transport: config.Transport{},
+			status:    http.StatusBadGateway,
+		},
+	}
+
+	var c config.Config
+	for _, tt := range tests {
+		c.Services = append(c.Services, config.Service{
+			Parser: config.Parser{
+				Pattern:     "^" + tt.endpoint + "$",
+				Methods:     []string{"GET"},
+				ConfigType:  "pass",
+				PassBaseURI: backend.URL,
+				Transport:   tt.transport,
+			},
+		})
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.endpoint, nil)
+		assert.NoError(err)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+
+		assert.Equal(tt.status, rr.Code, tt.endpoint)
+		if tt.body != "" {
+			assert.Equal(tt.body, rr.Body.String(), tt.endpoint)
+		}
+	}
+	assert.NotZero(atomic.LoadInt32(&open))
+
+	// the connections of the removed parsers are closed
+	assert.NoError(p.Reload(config.Config{Services: c.Services[len(c.Services)-1:]}))
+	assert.Eventually(func() bool { return atomic.LoadInt32(&open) == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func Test_processor_Process__passLoadBalancing(t *testing.T) {
+	assert := assert.New(t)
+
+	newBackend := func(name string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+
+	a := newBackend("a", http.StatusOK)
+	defer a.Close()
+	b := newBackend("b", http.StatusOK)
+	defer b.Close()
+	failing := newBackend("failing", http.StatusInternalServerError)
+	defer failing.Close()
+
+	tests := []struct {
+		endpoint      string
+		loadBalancing config.LoadBalancing
+		upstreams     []config.Upstream
+		// responses of consecutive requests, checked separately when nil
+		want []string
+	}{
+		{
+			endpoint:  "/round-robin",
+			upstreams: []config.Upstream{{URI: a.URL}, {URI: b.URL}},
+			want:      []string{"a", "b", "a", "b"},
+		},
+		{
+			endpoint:      "/weighted",
+			loadBalancing: config.LoadBalancing{Strategy: "weighted"},
+			upstreams:     []config.Upstream{{URI: a.URL, Weight: 3}, {URI: b.URL}},
+			want:          []string{"a", "a", "b", "a"},
+		},
+		{
+			endpoint:      "/sticky",
+			loadBalancing: config.LoadBalancing{Strategy: "sticky", StickyHeader: "x-user"},
+			upstreams:     []config.Upstream{{URI: a.URL}, {URI: b.URL}},
+		},
+		{
+			// the failing upstream is ejected after its second failure
+			endpoint:      "/eject",
+			loadBalancing: config.LoadBalancing{Eject: config.Eject{Failures: 2, Duration: "1m"}},
+			upstreams:     []config.Upstream{{URI: failing.URL}, {URI: a.URL}},
+			want:          []string{"failing", "a", "failing", "a", "a", "a"},
+		},
+		{
+			endpoint:      "/health-check",
+			loadBalancing: config.LoadBalancing{HealthCheck: config.HealthCheck{Path: "/health", Interval: "20ms"}},
+			upstreams:     []config.Upstream{{URI: failing.URL}, {URI: b.URL}},
+		},
+	}
+
+	var c config.Config
+	for _, tt := range tests {
+		c.Services = append(c.Services, config.Service{
+			Parser: config.Parser{
+				Pattern:       "^" + tt.endpoint + "$",
+				Methods:       []string{"GET"},
+				ConfigType:    "pass",
+				PassBaseURIs:  tt.upstreams,
+				LoadBalancing: tt.loadBalancing,
+			},
+		})
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	get := func(endpoint string, headers map[string]string) string {
+		return serve(t, http.HandlerFunc(p.Process), "GET", endpoint, "", headers).Body.String()
+	}
+
+	for _, tt := range tests {
+		if tt.want == nil {
+			continue
+		}
+
+		var got []string
+		for range tt.want {
+			got = append(got, get(tt.endpoint, nil))
+		}
+		assert.Equal(tt.want, got, tt.endpoint)
+	}
+
+	// each user keeps the same upstream
+	for _, user := range []string{"alice", "bob", "carol"} {
+		first := get("/sticky", map[string]string{"x-user": user})
+		for i := 0; i < 3; i++ {
+			assert.Equal(first, get("/sticky", map[string]string{"x-user": user}), user)
+		}
+	}
+
+	// health checks start with the first request
+	get("/health-check", nil)
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		assert.Equal("b", get("/health-check", nil))
+	}
+}
+
+func Test_processor_Process__exec(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+		fmt.Fprintf(w, "%s %s %s", r.URL.Path, r.Header.Get("X-Plugin"), body)
+	}))
+	defer backend.Close()
+
+	user := config.Response{
+		Status:   map[string]int{"GET": 202},
+		BodyType: "exec",
+		Exec: config.Exec{
+			Command: []string{"sh", "testdata/exec/user.sh"},
+			Timeout: "500ms",
+		},
+	}
+	worker := user
+	worker.Exec.Worker = true
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:    "^/users/(?P<id>\\w+)$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Response:   user,
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:    "^/workers/(?P<id>\\w+)$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Response:   worker,
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:     "^/pass$",
+					Methods:     []string{"POST"},
+					ConfigType:  "pass",
+					PassBaseURI: backend.URL,
+					TransformExec: config.Exec{
+						Command: []string{"sh", "testdata/exec/transform.sh"},
+					},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	process := http.HandlerFunc(p.Process)
+
+	callRe := regexp.MustCompile(`^user (\w+) call (\d+) pid (\d+)$`)
+	call := func(endpoint string) []string {
+		rr := serve(t, process, "GET", endpoint, "", nil)
+		assert.Equal(202, rr.Code)
+		assert.Equal("user", rr.Header().Get("x-plugin"))
+		return callRe.FindStringSubmatch(rr.Body.String())
+	}
+
+	// a process for each request
+	first, second := call("/users/42"), call("/users/43")
+	assert.Equal([]string{"42", "1"}, first[1:3])
+	assert.Equal([]string{"43", "1"}, second[1:3])
+
+	rr := serve(t, process, "GET", "/users/bad", "", nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+	assert.Equal("error running exec plugin: bad status 1000, it must be from 100 to 999", rr.Body.String())
+
+	// worker mode keeps the process running, and restarts it after crashes and timeouts
+	first, second = call("/workers/42"), call("/workers/43")
+	assert.Equal([]string{"42", "1"}, first[1:3])
+	assert.Equal([]string{"43", "2"}, second[1:3])
+	assert.Equal(first[3], second[3])
+
+	rr = serve(t, process, "GET", "/workers/crash", "", nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+	restarted := call("/workers/44")
+	assert.Equal([]string{"44", "1"}, restarted[1:3])
+	assert.NotEqual(first[3], restarted[3])
+
+	rr = serve(t, process, "GET", "/workers/slow", "", nil)
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+	assert.Contains(rr.Body.String(), "timed out after 500ms")
+	assert.Equal([]string{"45", "1"}, call("/workers/45")[1:3])
+
+	rr = serve(t, process, "POST", "/pass", "hello", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("/transformed transform hello!", rr.Body.String())
+
+	assert.NoError(p.Reload(config.Config{}))
+}
+
+func Test_processor_Process__execStopped(t *testing.T) {
+	assert := assert.New(t)
+	started := filepath.Join(t.TempDir(), "started")
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:    "^/workers/(?P<id>\\w+)$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					// the request is still waiting when the parser is removed
+					Delay: config.Delay{Min: "200ms", Max: "200ms"},
+					Response: config.Response{
+						Status:   map[string]int{"GET": 202},
+						BodyType: "exec",
+						Exec: config.Exec{
+							Command: []string{"sh", "-c", "echo $$ >> " + started + "; exec sh testdata/exec/user.sh"},
+							Worker:  true,
+						},
+					},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(t, http.HandlerFunc(p.Process), "GET", "/workers/42", "", nil)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(p.Reload(config.Config{}))
+
+	// the removed worker answers the late request with an error instead of starting a process
+	rr := <-done
+	assert.Equal(http.StatusInternalServerError, rr.Code)
+	assert.Contains(rr.Body.String(), "is stopped")
+	assert.NoFileExists(started)
+}
+
+func Test_processor_Process__fault(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		endpoint   string
+		fault      config.Fault
+		requestErr bool
+		status     int
+		body       string
+		bodyErr    error
+	}{
+		{
+			endpoint: "/abort",
+			fault:    config.Fault{Abort: config.AbortFault{Probability: 1, Status: 503}},
+			status:   http.StatusServiceUnavailable,
+			body:     "fault injected",
+		},
+		{endpoint: "/close", fault: config.Fault{CloseConnection: 1}, requestErr: true},
+		{endpoint: "/reset", fault: config.Fault{Reset: 1}, requestErr: true},
+		{endpoint: "/garbage", fault: config.Fault{Garbage: 1}, requestErr: true},
+		{
+			endpoint: "/truncate",
+			fault:    config.Fault{TruncateBody: 1},
+			status:   http.StatusOK,
+			bodyErr:  io.ErrUnexpectedEOF,
+		},
+		{
+			endpoint: "/hang",
+			fault:    config.Fault{Hang: config.HangFault{Probability: 1, Duration: "50ms"}},
+			status:   http.StatusOK,
+			bodyErr:  io.ErrUnexpectedEOF,
+		},
+		{
+			endpoint: "/never",
+			fault:    config.Fault{Abort: config.AbortFault{Probability: 0, Status: 503}},
+			status:   http.StatusOK,
+			body:     "a response body",
+		},
+	}
+
+	var c config.Config
+	for _, tt := range tests {
+		c.Services = append(c.Services, config.Service{
+			Parser: config.Parser{
+				Pattern:    "^" + tt.endpoint + "$",
+				Methods:    []string{"GET"},
+				ConfigType: "mock",
+				Fault:      tt.fault,
+				Response: config.Response{
+					Headers:  map[string]string{"content-type": "text/plain"},
+					Status:   map[string]int{"GET": 200},
+					BodyType: "fixed",
+					Body:     "a response body",
+				},
+			},
+		})
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	server := httptest.NewServer(http.HandlerFunc(p.Process))
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	for _, tt := range tests {
+		resp, err := client.Get(server.URL + tt.endpoint)
+		if tt.requestErr {
+			assert.Error(err, tt.endpoint)
+			continue
+		}
+		assert.NoError(err, tt.endpoint)
+		assert.Equal(tt.status, resp.StatusCode, tt.endpoint)
+		assert.Equal("text/plain", resp.Header.Get("content-type"), tt.endpoint)
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if tt.bodyErr != nil {
+			assert.ErrorIs(err, tt.bodyErr, tt.endpoint)
+			continue
+		}
+		assert.NoError(err, tt.endpoint)
+		assert.Equal(tt.body, string(body), tt.endpoint)
+	}
+}
+
+func Test_processor_Process__delay(t *testing.T) {
+	assert := assert.New(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("upstream"))
+	}))
+	defer backend.Close()
+
+	// mock delays are tested in Test_processor_Process
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:     "^/pass$",
+					Methods:     []string{"GET"},
+					ConfigType:  "pass",
+					PassBaseURI: backend.URL,
+					Delay:       config.Delay{Distribution: "fixed", Value: "100ms", After: true},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	req, err := http.NewRequest("GET", "/pass", nil)
+	assert.NoError(err)
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+
+	assert.Equal("upstream", rr.Body.String())
+	assert.GreaterOrEqual(int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func Test_processor_Process__throttle(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := strings.Repeat("x", 1000)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(payload))
+	}))
+	defer backend.Close()
+
+	throttle := config.Throttle{BytesPerSecond: 4000, ChunkSize: 100, TimeToFirstByte: "100ms"}
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:    "^/mock$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Throttle:   throttle,
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     payload,
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Pattern:     "^/pass$",
+					Methods:     []string{"GET"},
+					ConfigType:  "pass",
+					PassBaseURI: backend.URL,
+					Throttle:    throttle,
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	server := httptest.NewServer(http.HandlerFunc(p.Process))
+	defer server.Close()
+
+	for _, endpoint := range []string{"/mock", "/pass"} {
+		start := time.Now()
+		resp, err := http.Get(server.URL + endpoint)
+		assert.NoError(err, endpoint)
+		firstByte := time.Since(start)
+
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(err, endpoint)
+		resp.Body.Close()
+		elapsed := time.Since(start)
+
+		assert.Equal(payload, string(body), endpoint)
+		assert.GreaterOrEqual(int64(firstByte), int64(100*time.Millisecond), endpoint)
+		// 10 chunks at 4000 bytes/s: the last one is sent 225ms after the first
+		assert.GreaterOrEqual(int64(elapsed), int64(320*time.Millisecond), endpoint)
+	}
+}
+
+func Test_processor_Process__seed(t *testing.T) {
+	assert := assert.New(t)
+
+	c := config.Config{
+		Seed: 42,
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:    ".*",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Fault:      config.Fault{Abort: config.AbortFault{Probability: 0.5, Status: 503}},
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "ok",
+					},
+				},
+			},
+		},
+	}
+
+	statuses := func() []int {
+		p, err := processor.NewFromConfig(c)
+		assert.NoError(err)
+
+		var out []int
+		for i := 0; i < 20; i++ {
+			req, err := http.NewRequest("GET", "/", nil)
+			assert.NoError(err)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+			out = append(out, rr.Code)
+		}
+		return out
+	}
+
+	first := statuses()
+	assert.Contains(first, 200)
+	assert.Contains(first, 503)
+	assert.Equal(first, statuses())
+}
+
+func Test_processor_Process__scenario(t *testing.T) {
+	assert := assert.New(t)
+
+	states := []struct {
+		required string
+		next     string
+		body     string
+	}{
+		{required: processor.ScenarioStarted, next: "pending-2", body: "PENDING"},
+		{required: "pending-2", next: "done", body: "PENDING"},
+		{required: "done", body: "DONE"},
+	}
+
+	var c config.Config
+	for _, state := range states {
+		c.Services = append(c.Services, config.Service{
+			Parser: config.Parser{
+				Pattern:    "^/job$",
+				Methods:    []string{"GET"},
+				ConfigType: "mock",
+				Scenario: config.Scenario{
+					Name:          "job",
+					RequiredState: state.required,
+					NewState:      state.next,
+				},
+				Response: config.Response{
+					Status:   map[string]int{"GET": 200},
+					BodyType: "fixed",
+					Body:     state.body,
+				},
+			},
+		})
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	admin := p.Admin()
+	process := http.HandlerFunc(p.Process)
+
+	for _, want := range []string{"PENDING", "PENDING", "DONE", "DONE"} {
+		rr := serve(t, process, "GET", "/job", "", nil)
+		assert.Equal(http.StatusOK, rr.Code)
+		assert.Equal(want, rr.Body.String())
+	}
+
+	rr := serve(t, admin, "GET", processor.AdminPrefix+"/scenarios", "", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.JSONEq(`{"job": "done"}`, rr.Body.String())
+
+	rr = serve(t, admin, "DELETE", processor.AdminPrefix+"/scenarios", "", nil)
+	assert.Equal(http.StatusNoContent, rr.Code)
+
+	rr = serve(t, process, "GET", "/job", "", nil)
+	assert.Equal("PENDING", rr.Body.String())
+
+	rr = serve(t, admin, "PUT", processor.AdminPrefix+"/scenarios/job", `{"state": "done"}`, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	rr = serve(t, process, "GET", "/job", "", nil)
+	assert.Equal("DONE", rr.Body.String())
+
+	rr = serve(t, admin, "GET", processor.AdminPrefix+"/scenarios/job", "", nil)
+	assert.JSONEq(`{"state": "done"}`, rr.Body.String())
+
+	// concurrent requests never match the same state twice
+	serve(t, admin, "DELETE", processor.AdminPrefix+"/scenarios", "", nil)
+	bodies := make(chan string, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(bodies); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies <- serve(t, process, "GET", "/job", "", nil).Body.String()
+		}()
+	}
+	wg.Wait()
+	close(bodies)
+
+	pending := 0
+	for body := range bodies {
+		if body == "PENDING" {
+			pending++
+		}
+	}
+	assert.Equal(2, pending)
+}
+
+func Test_processor_Process__record(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+
+		w.Header().Add("Content-Type", "application/json")
+		w.Header().Add("X-Upstream", r.URL.Query().Get("id"))
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.WriteHeader(201)
+		_, _ = w.Write([]byte(`{"path": "` + r.URL.Path + `", "request": ` + string(body) + `}`))
+	}))
+	defer backend.Close()
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:     "/record.*",
+					Methods:     []string{"POST"},
+					ConfigType:  "pass",
+					PassBaseURI: backend.URL,
+					Record: config.Record{
+						Dir:     dir,
+						Headers: []string{"X-Tenant"},
+					},
+				},
+			},
+		},
+	}
+
+	type call struct {
+		endpoint string
+		body     string
+		tenant   string
+	}
+	calls := []call{
+		{endpoint: "/record/a?id=1", body: `{"n": 1}`, tenant: "t1"},
+		{endpoint: "/record/a?id=2", body: `{"n": 2}`, tenant: "t1"},
+		{endpoint: "/record/b?id=3&id=4", body: `{"n": 3}`, tenant: "t2"},
+		{endpoint: "/record/c?id=5", body: `{"n": 5}`, tenant: "t1"},
+	}
+
+	do := func(p processor.Processor, c call) *httptest.ResponseRecorder {
+		return serve(t, http.HandlerFunc(p.Process), "POST", c.endpoint, c.body, map[string]string{"X-Tenant": c.tenant})
+	}
+
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	var recorded []*httptest.ResponseRecorder
+	for i, call := range calls {
+		// restarting continues the recording in the folder
+		if i == 2 {
+			p, err = processor.NewFromConfig(c)
+			assert.NoError(err)
+			do(p, calls[0])
+		}
+
+		rr := do(p, call)
+		assert.Equal(201, rr.Code)
+		recorded = append(recorded, rr)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, processor.RecordConfigFile))
+	assert.NoError(err)
+
+	var replayConf config.Config
+	assert.NoError(yaml.Unmarshal(b, &replayConf))
+	assert.Len(replayConf.Services, len(calls))
+
+	// the recording is served both by its mock services and by a replay parser
+	replayConfigs := []struct {
+		name   string
+		config config.Config
+	}{
+		{name: "mock", config: replayConf},
+		{
+			name: "replay",
+			config: config.Config{
+				Services: []config.Service{
+					{
+						Parser: config.Parser{
+							Pattern:    "/record.*",
+							Methods:    []string{"POST"},
+							ConfigType: "replay",
+							Replay: config.Replay{
+								Dir:     dir,
+								Headers: []string{"X-Tenant"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range replayConfigs {
+		replay, err := processor.NewFromConfig(tt.config)
+		assert.NoError(err, tt.name)
+
+		for i, c := range calls {
+			rr := do(replay, c)
+			assert.Equal(recorded[i].Code, rr.Code, tt.name)
+			assert.Equal(recorded[i].Body.String(), rr.Body.String(), tt.name)
+			assert.Equal(recorded[i].Header().Get("X-Upstream"), rr.Header().Get("X-Upstream"), tt.name)
+			assert.Equal([]string{"a=1", "b=2"}, rr.Header().Values("Set-Cookie"), tt.name)
+		}
+
+		rr := do(replay, call{endpoint: "/record/a?id=1", body: `{"n": 1}`, tenant: "t2"})
+		assert.Equal(http.StatusNotFound, rr.Code, tt.name)
+	}
+}
+
+func Test_processor_Process__recordUpstreamDown(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("recorded"))
+	}))
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:     "/record.*",
+					Methods:     []string{"GET"},
+					ConfigType:  "pass",
+					PassBaseURI: backend.URL,
+					Record:      config.Record{Dir: dir},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	do := func() *httptest.ResponseRecorder {
+		return serve(t, http.HandlerFunc(p.Process), "GET", "/record/a", "", nil)
+	}
+
+	assert.Equal(http.StatusOK, do().Code)
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(err)
+	recording := make(map[string][]byte)
+	for _, f := range files {
+		recording[f], err = os.ReadFile(f)
+		assert.NoError(err)
+	}
+
+	// the 502 of the proxy doesn't replace the recording
+	backend.Close()
+	assert.Equal(http.StatusBadGateway, do().Code)
+
+	files, err = filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(err)
+	assert.Len(files, len(recording))
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		assert.NoError(err)
+		assert.Equal(string(recording[f]), string(b), f)
+	}
+}
+
+func Test_processor_Process__replay(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	hits := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(err)
+
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(r.Header.Get("X-Tenant") + ":" + string(body)))
+	}))
+	defer backend.Close()
+
+	parser := config.Parser{
+		Pattern:     "/replay.*",
+		Methods:     []string{"POST"},
+		ConfigType:  "replay",
+		PassBaseURI: backend.URL,
+		Replay: config.Replay{
+			Dir:      dir,
+			Headers:  []string{"X-Tenant"},
+			Fallback: true,
+		},
+	}
+
+	type step struct {
+		body   string
+		tenant string
+		status int
+		want   string
+		hits   int
+	}
+
+	run := func(p processor.Processor, steps []step) {
+		for _, s := range steps {
+			rr := serve(t, http.HandlerFunc(p.Process), "POST", "/replay?x=1", s.body, map[string]string{"X-Tenant": s.tenant})
+
+			assert.Equal(s.status, rr.Code)
+			if s.want != "" {
+				assert.Equal(s.want, rr.Body.String())
+				assert.Equal("text/plain", rr.Header().Get("Content-Type"))
+			}
+			assert.Equal(s.hits, hits)
+		}
+	}
+
+	online, err := processor.NewFromConfig(config.Config{Services: []config.Service{{Parser: parser}}})
+	assert.NoError(err)
+
+	run(online, []step{
+		{body: "one", tenant: "t1", status: 200, want: "t1:one", hits: 1},
+		{body: "one", tenant: "t1", status: 200, want: "t1:one", hits: 1},
+		{body: "one", tenant: "t2", status: 200, want: "t2:one", hits: 2},
+		// not a JSON body, so the generated mock can't tell it from "one", but replay can
+		{body: "two", tenant: "t2", status: 200, want: "t2:two", hits: 3},
+		{body: "one", tenant: "t2", status: 200, want: "t2:one", hits: 3},
+	})
+
+	_, err = os.Stat(filepath.Join(dir, processor.RecordConfigFile))
+	assert.NoError(err)
+
+	parser.Replay.Fallback = false
+	offline, err := processor.NewFromConfig(config.Config{Services: []config.Service{{Parser: parser}}})
+	assert.NoError(err)
+
+	run(offline, []step{
+		{body: "one", tenant: "t2", status: 200, want: "t2:one", hits: 3},
+		{body: "two", tenant: "t2", status: 200, want: "t2:two", hits: 3},
+		{body: "two", tenant: "t1", status: http.StatusNotFound, hits: 3},
+	})
+}
+
+func Test_processor_Process__replayLoadBalancing(t *testing.T) {
+	assert := assert.New(t)
+
+	var backends []*httptest.Server
+	for _, name := range []string{"a", "b"} {
+		name := name
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+		defer backend.Close()
+		backends = append(backends, backend)
+	}
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:    "/replay.*",
+					Methods:    []string{"POST"},
+					ConfigType: "replay",
+					PassBaseURIs: []config.Upstream{
+						{URI: backends[0].URL},
+						{URI: backends[1].URL},
+					},
+					Replay: config.Replay{Dir: t.TempDir(), Fallback: true},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: "one", want: "a"},
+		{body: "two", want: "b"},
+		// recorded, not sent to the next upstream
+		{body: "one", want: "a"},
+	}
+
+	for _, tt := range tests {
+		rr := serve(t, http.HandlerFunc(p.Process), "POST", "/replay", tt.body, nil)
+		assert.Equal(http.StatusOK, rr.Code, tt.body)
+		assert.Equal(tt.want, rr.Body.String(), tt.body)
 	}
 }
 
-func Test_processor_Process__pass(t *testing.T) {
+func Test_processor_Process__replayUpstreamDown(t *testing.T) {
 	assert := assert.New(t)
-	os.Setenv("MIRAGE_MOCKER_TEST_VAR", "mirage-mocker")
+	dir := t.TempDir()
 
-	inBody := map[string]string{"some": "value"}
-	inJSON, err := json.Marshal(inBody)
+	// the upstream address is reserved, and nothing listens on it until the upstream starts
+	l, err := net.Listen("tcp", "127.0.0.1:0")
 	assert.NoError(err)
+	addr := l.Addr().String()
+	assert.NoError(l.Close())
 
-	outBody := map[string]string{"other": "value"}
-	outJSON, err := json.Marshal(outBody)
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:     "/replay.*",
+					Methods:     []string{"GET"},
+					ConfigType:  "replay",
+					PassBaseURI: "http://" + addr,
+					Replay:      config.Replay{Dir: dir, Fallback: true},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	do := func() *httptest.ResponseRecorder {
+		return serve(t, http.HandlerFunc(p.Process), "GET", "/replay/a", "", nil)
+	}
+
+	assert.Equal(http.StatusBadGateway, do().Code)
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
 	assert.NoError(err)
+	assert.Empty(files)
 
+	// the 502 wasn't replayed, the request goes to the upstream once it is up
+	l, err = net.Listen("tcp", addr)
+	assert.NoError(err)
 	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Equal(r.Header.Get("OTHER_HEADER"), "otherHeader")
-		assert.Equal(r.Header.Get("MIRAGE_MOCKER_TEST_VAR"), "mirage-mocker")
-		assert.Equal(r.URL.Path, "/pass")
+		_, _ = w.Write([]byte("upstream"))
+	}))
+	backend.Listener = l
+	backend.Start()
+	defer backend.Close()
 
-		body := r.Body
-		defer body.Close()
-		bodyBytes, err := io.ReadAll(body)
-		assert.EqualValues(inJSON, bodyBytes)
+	rr := do()
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("upstream", rr.Body.String())
+}
 
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(200)
+func Test_processor_Admin__parsers(t *testing.T) {
+	assert := assert.New(t)
 
-		if err != nil {
-			assert.NoError(err)
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Name:       "ping",
+					Pattern:    "^/ping$",
+					Methods:    []string{"GET"},
+					ConfigType: "mock",
+					Response: config.Response{
+						Status:   map[string]int{"GET": 200},
+						BodyType: "fixed",
+						Body:     "pong",
+					},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	admin := p.Admin()
+	process := http.HandlerFunc(p.Process)
+
+	rr := serve(t, admin, "GET", processor.AdminPrefix+"/parsers", "", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	var entries []struct {
+		Index  int           `json:"index"`
+		Parser config.Parser `json:"parser"`
+	}
+	assert.NoError(json.Unmarshal(rr.Body.Bytes(), &entries))
+	assert.Len(entries, 1)
+	assert.Equal("ping", entries[0].Parser.Name)
+	assert.Equal("pong", entries[0].Parser.Response.Body)
+
+	// YAML, inserted before the existing parser
+	rr = serve(t, admin, "POST", processor.AdminPrefix+"/parsers?index=0", `
+name: ping-v2
+pattern: ^/ping$
+methods: [ GET ]
+type: mock
+response:
+  status:
+    GET: 200
+  body-type: fixed
+  body: pong v2
+`, nil)
+	assert.Equal(http.StatusCreated, rr.Code)
+	assert.Equal("pong v2", serve(t, process, "GET", "/ping", "", nil).Body.String())
+
+	// JSON, replacing by name
+	rr = serve(t, admin, "PUT", processor.AdminPrefix+"/parsers/ping-v2", `{"name": "ping-v2", "pattern": "^/ping$",
+		"methods": ["GET"], "type": "mock", "response": {"status": {"GET": 202}, "body-type": "fixed", "body": "pong v3"}}`, nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	rr = serve(t, process, "GET", "/ping", "", nil)
+	assert.Equal(202, rr.Code)
+	assert.Equal("pong v3", rr.Body.String())
+
+	rr = serve(t, admin, "PUT", processor.AdminPrefix+"/parsers/0", `{"pattern": "^/ping$", "type": "unknown"}`, nil)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = serve(t, admin, "DELETE", processor.AdminPrefix+"/parsers/0", "", nil)
+	assert.Equal(http.StatusNoContent, rr.Code)
+	assert.Equal("pong", serve(t, process, "GET", "/ping", "", nil).Body.String())
+
+	rr = serve(t, admin, "DELETE", processor.AdminPrefix+"/parsers/ping-v2", "", nil)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	// concurrent changes by name apply to the named parsers
+	names := []string{"n0", "n1", "n2", "n3", "n4", "n5", "n6", "n7"}
+	for _, name := range names {
+		rr = serve(t, admin, "POST", processor.AdminPrefix+"/parsers", `{"name": "`+name+`", "pattern": "^/`+name+`$",
+			"methods": ["GET"], "type": "mock", "response": {"status": {"GET": 200}, "body-type": "fixed"}}`, nil)
+		assert.Equal(http.StatusCreated, rr.Code)
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			rr := serve(t, admin, "DELETE", processor.AdminPrefix+"/parsers/"+name, "", nil)
+			assert.Equal(http.StatusNoContent, rr.Code)
+		}(name)
+	}
+	wg.Wait()
+
+	rr = serve(t, admin, "GET", processor.AdminPrefix+"/parsers", "", nil)
+	assert.NoError(json.Unmarshal(rr.Body.Bytes(), &entries))
+	assert.Len(entries, 1)
+	assert.Equal("ping", entries[0].Parser.Name)
+
+	select {
+	case <-p.Done():
+		t.Fatal("done before shutdown")
+	default:
+	}
+
+	rr = serve(t, admin, "POST", processor.AdminPrefix+"/shutdown", "", nil)
+	assert.Equal(http.StatusAccepted, rr.Code)
+	<-p.Done()
+}
+
+func Test_processor_Admin__journal(t *testing.T) {
+	assert := assert.New(t)
+
+	c := config.Config{
+		JournalSize: 3,
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Name:       "orders",
+					Pattern:    "^/orders$",
+					Methods:    []string{"POST"},
+					ConfigType: "mock",
+					Response: config.Response{
+						Status:   map[string]int{"POST": 201},
+						BodyType: "echo",
+					},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(err)
+
+	tenant := map[string]string{"X-Tenant": "t1"}
+	admin := p.Admin()
+	process := http.HandlerFunc(p.Process)
+
+	count := func(filter string) int {
+		rr := serve(t, admin, "GET", processor.AdminPrefix+"/requests/count?"+filter, "", tenant)
+		assert.Equal(http.StatusOK, rr.Code)
+
+		var out struct {
+			Count int `json:"count"`
 		}
-		_, _ = w.Write(outJSON)
-	}))
+		assert.NoError(json.Unmarshal(rr.Body.Bytes(), &out))
+		return out.Count
+	}
 
-	backend.Start()
-	defer backend.Close()
+	serve(t, process, "POST", "/orders", `{"id": 1, "items": ["a"]}`, tenant)
+	serve(t, process, "POST", "/orders?dry-run=true", `{"id": 2, "items": ["a", "b"]}`, tenant)
+	serve(t, process, "GET", "/unknown", "", tenant)
+
+	assert.Equal(3, count(""))
+	assert.Equal(2, count("parser=orders&status=201"))
+	assert.Equal(1, count("matched=false&path=^/unk"))
+	assert.Equal(1, count("query=dry-run%3Dtrue"))
+	assert.Equal(2, count(`body-json={"items":["a"]}`))
+	assert.Equal(1, count(`body-json={"items":["b"]}`))
+	assert.Equal(2, count("method=post&header=X-Tenant:t1&body-contains=items"))
+
+	rr := serve(t, admin, "GET", processor.AdminPrefix+"/requests?status=404", "", tenant)
+	var entries []struct {
+		Path   string `json:"path"`
+		Status int    `json:"status"`
+	}
+	assert.NoError(json.Unmarshal(rr.Body.Bytes(), &entries))
+	assert.Len(entries, 1)
+	assert.Equal("/unknown", entries[0].Path)
+
+	// bounded to the last 3 requests
+	serve(t, process, "GET", "/unknown2", "", tenant)
+	assert.Equal(3, count(""))
+	assert.Equal(1, count("parser=orders"))
+
+	rr = serve(t, admin, "GET", processor.AdminPrefix+"/requests?status=abc", "", tenant)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+
+	rr = serve(t, admin, "DELETE", processor.AdminPrefix+"/requests", "", tenant)
+	assert.Equal(http.StatusNoContent, rr.Code)
+	assert.Equal(0, count(""))
+}
+
+func Test_processor_Reload(t *testing.T) {
+	assert := assert.New(t)
+
+	ping := config.Parser{
+		Pattern:    "^/ping$",
+		Methods:    []string{"GET"},
+		ConfigType: "mock",
+		Response: config.Response{
+			Status:   map[string]int{"GET": 200},
+			BodyType: "fixed",
+			Body:     "pong",
+		},
+	}
+	p, err := processor.NewFromConfig(config.Config{Services: []config.Service{{Parser: ping}}})
+	assert.NoError(err)
+
+	tests := []struct {
+		name     string
+		bodyType string
+		body     string
+		wantErr  bool
+		want     string
+	}{
+		{name: "bad config keeps the parsers", bodyType: "unknown", body: "pong v2", wantErr: true, want: "pong"},
+		{name: "good config replaces the parsers", bodyType: "fixed", body: "pong v2", want: "pong v2"},
+	}
+
+	for _, tt := range tests {
+		parser := ping
+		parser.Response.BodyType = tt.bodyType
+		parser.Response.Body = tt.body
+
+		err := p.Reload(config.Config{Services: []config.Service{{Parser: parser}}})
+		if tt.wantErr {
+			assert.Error(err, tt.name)
+		} else {
+			assert.NoError(err, tt.name)
+		}
+
+		rr := serve(t, http.HandlerFunc(p.Process), "GET", "/ping", "", nil)
+		assert.Equal(tt.want, rr.Body.String(), tt.name)
+	}
+}
+
+func Test_processor_Process__openapi(t *testing.T) {
+	assert := assert.New(t)
 
 	c := config.Config{
 		Services: []config.Service{
 			{
 				Parser: config.Parser{
-					Pattern: "/test/pass.*",
-					Rewrites: []config.Rewrite{
-						{
-							Source: "/test(/.*)",
-							Target: "$1",
-						},
+					Name:       "pets",
+					ConfigType: "openapi",
+					OpenAPI: config.OpenAPI{
+						Spec:     "../openapi/testdata/petstore.yml",
+						BasePath: "/api",
 					},
-					Methods:         []string{"POST"},
-					ConfigType:      "pass",
-					Log:             true,
-					TransformLib:    "testdata/transform/transform.so",
-					TransformSymbol: "AddHeader",
-					PassBaseURI:     backend.URL,
 				},
 			},
 		},
@@ -432,17 +2761,225 @@ func Test_processor_Process__pass(t *testing.T) {
 	p, err := processor.NewFromConfig(c)
 	assert.NoError(err)
 
-	req, err := http.NewRequest("POST", "/test/pass?vname=MIRAGE_MOCKER_TEST_VAR", bytes.NewReader(inJSON))
+	process := http.HandlerFunc(p.Process)
+
+	rr := serve(t, process, "GET", "/api/pets/mine", "", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("no pets", rr.Body.String())
+
+	rr = serve(t, process, "GET", "/api/pets/12", "", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+	assert.Equal("application/json", rr.Header().Get("content-type"))
+	assert.JSONEq(`{"birth": "2021-01-01", "id": 1, "name": "Fido", "tag": "dog"}`, rr.Body.String())
+
+	rr = serve(t, process, "POST", "/api/pets", "", nil)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	rr = serve(t, process, "DELETE", "/api/pets/12", "", nil)
+	assert.Equal(http.StatusNoContent, rr.Code)
+
+	rr = serve(t, process, "GET", "/v1/pets", "", nil)
+	assert.Equal(http.StatusNotFound, rr.Code)
+
+	rr = serve(t, p.Admin(), "GET", processor.AdminPrefix+"/parsers/pets.showPetById", "", nil)
+	assert.Equal(http.StatusOK, rr.Code)
+}
+
+func Test_processor_Process__validation(t *testing.T) {
+	assert := assert.New(t)
+
+	c := config.Config{
+		Services: []config.Service{
+			{
+				Parser: config.Parser{
+					Pattern:    "^/v1/pets$",
+					Methods:    []string{"POST"},
+					ConfigType: "mock",
+					Validation: config.Validation{
+						Spec: "../openapi/testdata/petstore.yml",
+						Mode: "reject",
+					},
+					Response: config.Response{
+						Status:   map[string]int{"POST": 201},
+						BodyType: "fixed",
+					},
+				},
+			},
+			{
+				Parser: config.Parser{
+					Name:       "pets",
+					ConfigType: "openapi",
+					OpenAPI: config.OpenAPI{
+						Spec: "../openapi/testdata/petstore.yml",
+					},
+					Validation: config.Validation{
+						Mode: "log",
+					},
+				},
+			},
+		},
+	}
+	p, err := processor.NewFromConfig(c)
 	assert.NoError(err)
-	req.Header.Add("OTHER_HEADER", "otherHeader")
 
-	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(p.Process)
+	headers := map[string]string{"content-type": "application/json"}
 
-	handler.ServeHTTP(rr, req)
+	rr := serve(t, http.HandlerFunc(p.Process), "POST", "/v1/pets", `{"name": "Rex"}`, headers)
+	assert.Equal(http.StatusCreated, rr.Code)
+
+	rr = serve(t, http.HandlerFunc(p.Process), "POST", "/v1/pets", `{"name": 10}`, headers)
+	assert.Equal(http.StatusBadRequest, rr.Code)
+	assert.Equal("request does not match the spec:\n- body: $.name: must be a string", rr.Body.String())
 
+	rr = serve(t, http.HandlerFunc(p.Process), "GET", "/v1/pets?limit=500", "", headers)
 	assert.Equal(http.StatusOK, rr.Code)
-	assert.Equal(string(outJSON), rr.Body.String())
-	assert.Equal("application/json", rr.Header().Get("Content-Type"))
 
+	rr = serve(t, p.Admin(), "GET", processor.AdminPrefix+"/requests?invalid=true", "", headers)
+	assert.Equal(http.StatusOK, rr.Code)
+
+	var entries []struct {
+		Path       string              `json:"path"`
+		Status     int                 `json:"status"`
+		Violations []openapi.Violation `json:"violations"`
+	}
+	assert.NoError(json.Unmarshal(rr.Body.Bytes(), &entries))
+	if assert.Len(entries, 2) {
+		assert.Equal(http.StatusBadRequest, entries[0].Status)
+		assert.Equal(http.StatusOK, entries[1].Status)
+		assert.Equal([]openapi.Violation{{In: "query", Name: "limit", Message: "must be <= 100"}}, entries[1].Violations)
+	}
+}
+
+func Test_processor_Process__routing(t *testing.T) {
+	// parsers in order, each answering its name
+	parsers := []struct {
+		pattern string
+		name    string
+	}{
+		{"^/users/admin$", "admin"},
+		{"/legacy/", "legacy"},
+		{"^/users/[0-9]+$", "user"},
+		{"(?i)^/CASE", "case"},
+		{"^(/a|/b)/x$", "alternation"},
+		{"^/users", "users"},
+		{"^/$", "root"},
+		{"x$", "ends with x"},
+	}
+
+	var c config.Config
+	for _, parser := range parsers {
+		c.Services = append(c.Services, config.Service{
+			Parser: config.Parser{
+				Pattern:    parser.pattern,
+				Methods:    []string{"GET"},
+				ConfigType: "mock",
+				Response: config.Response{
+					Status:   map[string]int{"GET": 200},
+					BodyType: "fixed",
+					Body:     parser.name,
+				},
+			},
+		})
+	}
+	p, err := processor.NewFromConfig(c)
+	assert.NoError(t, err)
+
+	tests := map[string]string{
+		"/users/admin":      "admin",
+		"/users/42":         "user",
+		"/users/legacy/42":  "legacy",
+		"/users/abc":        "users",
+		"/case/insensitive": "case",
+		"/b/x":              "alternation",
+		"/c/x":              "ends with x",
+		"/":                 "root",
+		"/other":            "",
+	}
+
+	for path, want := range tests {
+		t.Run(path, func(t *testing.T) {
+			req, err := http.NewRequest("GET", path, nil)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(p.Process).ServeHTTP(rr, req)
+
+			if want == "" {
+				assert.Equal(t, http.StatusNotFound, rr.Code)
+				return
+			}
+			assert.Equal(t, want, rr.Body.String())
+		})
+	}
+}
+
+func buildRoutesConfig(n int) config.Config {
+	var c config.Config
+	for i := 0; i < n; i++ {
+		pattern := fmt.Sprintf("^/service%d/items/[0-9]+$", i)
+		if i%10 == 0 {
+			pattern = fmt.Sprintf("/legacy%d/.*", i)
+		}
+
+		c.Services = append(c.Services, config.Service{
+			Parser: config.Parser{
+				Pattern:    pattern,
+				Methods:    []string{"GET"},
+				ConfigType: "mock",
+				Response: config.Response{
+					Status:   map[string]int{"GET": 200},
+					BodyType: "fixed",
+					Body:     "ok",
+				},
+			},
+		})
+	}
+
+	return c
+}
+
+func Benchmark_processor_Process__400routes(b *testing.B) {
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	p, err := processor.NewFromConfig(buildRoutesConfig(400))
+	if err != nil {
+		b.Fatal(err)
+	}
+	handler := http.HandlerFunc(p.Process)
+
+	req, err := http.NewRequest("GET", "/service399/items/42", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", rr.Code)
+		}
+	}
+}
+
+// Benchmark_regexpMatchString__400routes is the baseline of matching every pattern on each request,
+// as done before patterns were precompiled and indexed
+func Benchmark_regexpMatchString__400routes(b *testing.B) {
+	c := buildRoutesConfig(400)
+	path := "/service399/items/42"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, service := range c.Services {
+			match, err := regexp.MatchString(service.Parser.Pattern, path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if match {
+				break
+			}
+		}
+	}
 }
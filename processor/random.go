@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// random is a source of random numbers safe for concurrent use. Delays, faults and load balancing of a
+// processor draw from it, so a seed makes runs reproducible (as long as requests arrive in the same order)
+type random struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// newRandom creates a source with the seed, or with the current time when the seed is 0
+func newRandom(seed int64) *random {
+	rnd := &random{}
+	rnd.seed(seed)
+
+	return rnd
+}
+
+func (rnd *random) seed(seed int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	rnd.mu.Lock()
+	defer rnd.mu.Unlock()
+
+	rnd.r = rand.New(rand.NewSource(seed))
+}
+
+func (rnd *random) Float64() float64 {
+	rnd.mu.Lock()
+	defer rnd.mu.Unlock()
+
+	return rnd.r.Float64()
+}
+
+func (rnd *random) NormFloat64() float64 {
+	rnd.mu.Lock()
+	defer rnd.mu.Unlock()
+
+	return rnd.r.NormFloat64()
+}
+
+func (rnd *random) Int63n(n int64) int64 {
+	rnd.mu.Lock()
+	defer rnd.mu.Unlock()
+
+	return rnd.r.Int63n(n)
+}
+
+func (rnd *random) Intn(n int) int {
+	rnd.mu.Lock()
+	defer rnd.mu.Unlock()
+
+	return rnd.r.Intn(n)
+}
+
+func (rnd *random) Read(p []byte) {
+	rnd.mu.Lock()
+	defer rnd.mu.Unlock()
+
+	_, _ = rnd.r.Read(p)
+}
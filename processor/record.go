@@ -0,0 +1,325 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// RecordConfigFile is the name of the mirage configuration written by the recorder
+const RecordConfigFile = "mirage.yml"
+
+// ignoredResponseHeaders are not recorded since they are set by the server when replaying
+var ignoredResponseHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Date":              true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+}
+
+// exchange is a proxied request and its response, saved next to its response body file
+type exchange struct {
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	Query           url.Values        `json:"query,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            []byte            `json:"body,omitempty"`
+	Status          int               `json:"status"`
+	ResponseHeaders http.Header       `json:"response-headers,omitempty"`
+	BodyFile        string            `json:"body-file"`
+	ResponseBody    []byte            `json:"-"`
+}
+
+// newRequestExchange describes a request with the given headers, without response
+func newRequestExchange(r *http.Request, body []byte, headers []string) exchange {
+	e := exchange{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Headers: make(map[string]string),
+		Body:    body,
+	}
+
+	for _, h := range headers {
+		if v := r.Header.Get(h); v != "" {
+			e.Headers[http.CanonicalHeaderKey(h)] = v
+		}
+	}
+
+	return e
+}
+
+func newExchange(r *http.Request, body []byte, headers []string, cw *captureWriter) exchange {
+	e := newRequestExchange(r, body, headers)
+	e.Status = cw.Status()
+	e.ResponseHeaders = make(http.Header)
+	e.ResponseBody = cw.body.Bytes()
+
+	for k, v := range cw.Header() {
+		if !ignoredResponseHeaders[k] {
+			e.ResponseHeaders[k] = append([]string(nil), v...)
+		}
+	}
+
+	return e
+}
+
+// recorder persists proxied exchanges as a mirage configuration with mock services. Each exchange is
+// also saved as a JSON file, so the recording can be served by replay parsers
+type recorder struct {
+	dir     string
+	headers []string
+	// key identifies the exchanges replacing each other when recorded again
+	key func(exchange) (string, error)
+	// recorded, when set, is called with every exchange written
+	recorded  func(exchange)
+	mu        sync.Mutex
+	services  []config.Service
+	positions map[string]int
+}
+
+func newRecorder(conf config.Record, key func(exchange) (string, error)) (*recorder, error) {
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating record dir %s: %w", conf.Dir, err)
+	}
+
+	rec := &recorder{
+		dir:       conf.Dir,
+		headers:   conf.Headers,
+		key:       key,
+		positions: make(map[string]int),
+	}
+	if err := rec.load(); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// load continues the recording already in the folder, if any, so its services and body files are kept
+func (rec *recorder) load() error {
+	b, err := ioutil.ReadFile(filepath.Join(rec.dir, RecordConfigFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading recorded config: %w", err)
+	}
+
+	var c config.Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("error parsing recorded config: %w", err)
+	}
+
+	exchanges, err := readExchanges(rec.dir)
+	if err != nil {
+		return err
+	}
+
+	for pos, e := range exchanges {
+		if pos >= len(c.Services) {
+			continue
+		}
+		key, err := rec.key(e)
+		if err != nil {
+			return err
+		}
+		rec.positions[key] = pos
+	}
+	rec.services = c.Services
+
+	return nil
+}
+
+// readExchanges reads the exchanges saved in a recording folder with their response bodies, by
+// position in the recording. A missing folder has no exchanges
+func readExchanges(dir string) (map[int]exchange, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := make(map[int]exchange, len(files))
+	for _, f := range files {
+		var pos int
+		if _, err := fmt.Sscanf(filepath.Base(f), "%04d-", &pos); err != nil {
+			// not an exchange file
+			continue
+		}
+
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading exchange: %w", err)
+		}
+
+		var e exchange
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, fmt.Errorf("error parsing exchange %s: %w", f, err)
+		}
+
+		e.ResponseBody, err = ioutil.ReadFile(filepath.Join(dir, e.BodyFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading exchange body: %w", err)
+		}
+
+		exchanges[pos] = e
+	}
+
+	return exchanges, nil
+}
+
+// mockKey identifies the requests matched by the service generated for an exchange
+func mockKey(e exchange) (string, error) {
+	p := mockFromExchange(e, "")
+	b, err := yaml.Marshal(config.Parser{
+		Pattern: p.Pattern,
+		Methods: p.Methods,
+		Headers: p.Headers,
+		Query:   p.Query,
+		Body:    p.Body,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding recorded service: %w", err)
+	}
+
+	return string(b), nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// record adds an exchange to the recording and rewrites the configuration file. A request recorded
+// again replaces the previous response
+func (rec *recorder) record(e exchange) error {
+	key, err := rec.key(e)
+	if err != nil {
+		return err
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	pos, ok := rec.positions[key]
+	if !ok {
+		pos = len(rec.services)
+		rec.services = append(rec.services, config.Service{})
+		rec.positions[key] = pos
+	}
+
+	name := fmt.Sprintf("%04d-%s", pos, strings.Trim(unsafeFileChars.ReplaceAllString(e.Method+"-"+e.Path, "_"), "_"))
+	bodyFile := filepath.Join(rec.dir, name+".body")
+	if err := ioutil.WriteFile(bodyFile, e.ResponseBody, 0644); err != nil {
+		return fmt.Errorf("error writing body file: %w", err)
+	}
+
+	e.BodyFile = filepath.Base(bodyFile)
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding exchange: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(rec.dir, name+".json"), b); err != nil {
+		return fmt.Errorf("error writing exchange: %w", err)
+	}
+
+	rec.services[pos] = config.Service{Parser: mockFromExchange(e, bodyFile)}
+
+	out, err := yaml.Marshal(config.Config{Services: rec.services})
+	if err != nil {
+		return fmt.Errorf("error encoding recorded config: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(rec.dir, RecordConfigFile), out); err != nil {
+		return err
+	}
+
+	if rec.recorded != nil {
+		rec.recorded(e)
+	}
+
+	return nil
+}
+
+func mockFromExchange(e exchange, bodyFile string) config.Parser {
+	p := config.Parser{
+		Pattern:    "^" + regexp.QuoteMeta(e.Path) + "$",
+		Methods:    []string{e.Method},
+		ConfigType: "mock",
+		Response: config.Response{
+			Status:   map[string]int{e.Method: e.Status},
+			BodyType: "fixed",
+			BodyFile: bodyFile,
+		},
+	}
+
+	if len(e.Headers) > 0 {
+		p.Headers = e.Headers
+	}
+
+	for k, v := range e.ResponseHeaders {
+		if len(v) == 1 {
+			if p.Response.Headers == nil {
+				p.Response.Headers = make(map[string]string)
+			}
+			p.Response.Headers[k] = v[0]
+			continue
+		}
+
+		if p.Response.MultiValueHeaders == nil {
+			p.Response.MultiValueHeaders = make(map[string][]string)
+		}
+		p.Response.MultiValueHeaders[k] = v
+	}
+
+	if len(e.Query) > 0 {
+		p.Query = make(map[string]config.Query, len(e.Query))
+		for k, v := range e.Query {
+			if len(v) == 1 {
+				p.Query[k] = config.Query{Equals: v[0]}
+				continue
+			}
+			p.Query[k] = config.Query{Values: v}
+		}
+	}
+
+	// only JSON bodies can be matched
+	if len(bytes.TrimSpace(e.Body)) > 0 && json.Valid(e.Body) {
+		p.Body.Equals = string(e.Body)
+	}
+
+	return p
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
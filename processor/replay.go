@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// defaultFingerprint are the request parts used to identify recorded exchanges when none are configured
+var defaultFingerprint = []string{"method", "path", "query", "body"}
+
+// replayParser serves exchanges previously recorded in a folder, optionally proxying (and recording)
+// the requests not found
+type replayParser struct {
+	baseParser
+	fingerprint []string
+	headers     []string
+	pass        *passParser
+	mu          sync.RWMutex
+	exchanges   map[string]exchange
+}
+
+// ProcessRequest process replay requests
+func (rp *replayParser) ProcessRequest(w http.ResponseWriter, r *http.Request) {
+	if rp.Log {
+		logRequest(r)
+	}
+
+	body, err := bufferBody(r)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Can't read body %v", err), 500)
+		return
+	}
+
+	key := rp.key(newRequestExchange(r, body, rp.headers))
+	rp.mu.RLock()
+	e, ok := rp.exchanges[key]
+	rp.mu.RUnlock()
+
+	if ok {
+		log.Debug().Msgf("replaying recorded exchange %s", e.BodyFile)
+		for k, values := range e.ResponseHeaders {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+		w.WriteHeader(e.Status)
+		_, _ = w.Write(e.ResponseBody)
+		return
+	}
+
+	if rp.pass == nil {
+		errorResponse(w, fmt.Sprintf("no recorded exchange for request %s %s", r.Method, r.URL.Path), 404)
+		return
+	}
+
+	// the pass parser records the response, adding it to the exchanges
+	log.Debug().Msgf("no recorded exchange %s, passing request", key)
+	rp.pass.ProcessRequest(w, r)
+}
+
+// GetBaseParser returns base request
+func (rp *replayParser) GetBaseParser() baseParser {
+	return rp.baseParser
+}
+
+// key returns the fingerprint of an exchange request
+func (rp *replayParser) key(e exchange) string {
+	h := sha256.New()
+	for _, part := range rp.fingerprint {
+		switch part {
+		case "method":
+			fmt.Fprintf(h, "method:%s\n", e.Method)
+		case "path":
+			fmt.Fprintf(h, "path:%s\n", e.Path)
+		case "query":
+			fmt.Fprintf(h, "query:%s\n", e.Query.Encode())
+		case "body":
+			fmt.Fprintf(h, "body:%x\n", sha256.Sum256(e.Body))
+		}
+	}
+
+	headers := append([]string(nil), rp.headers...)
+	sort.Strings(headers)
+	for _, k := range headers {
+		k = http.CanonicalHeaderKey(k)
+		fmt.Fprintf(h, "header:%s:%s\n", k, e.Headers[k])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (rp *replayParser) add(e exchange) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	rp.exchanges[rp.key(e)] = e
+}
+
+func createReplayParser(base baseParser, cr config.Parser) (*replayParser, error) {
+	if cr.Replay.Dir == "" {
+		return nil, errors.New("replay dir is required")
+	}
+
+	parser := &replayParser{
+		baseParser:  base,
+		fingerprint: cr.Replay.Fingerprint,
+		headers:     cr.Replay.Headers,
+		exchanges:   make(map[string]exchange),
+	}
+
+	if len(parser.fingerprint) == 0 {
+		parser.fingerprint = defaultFingerprint
+	}
+
+	for _, part := range parser.fingerprint {
+		if !containsString(defaultFingerprint, part) {
+			return nil, fmt.Errorf("bad fingerprint part %s", part)
+		}
+	}
+
+	exchanges, err := readExchanges(cr.Replay.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading replay dir %s: %w", cr.Replay.Dir, err)
+	}
+	for _, e := range exchanges {
+		parser.add(e)
+	}
+
+	if cr.Replay.Fallback {
+		pass, err := createPassParser(base, cr)
+		if err != nil {
+			return nil, fmt.Errorf("error creating replay fallback: %w", err)
+		}
+
+		// exchanges replace each other when they have the same fingerprint
+		pass.recorder, err = newRecorder(config.Record{Dir: cr.Replay.Dir, Headers: cr.Replay.Headers},
+			func(e exchange) (string, error) { return parser.key(e), nil })
+		if err != nil {
+			return nil, err
+		}
+		// the 502 of a failed upstream is not recorded, so the next requests are proxied again instead of
+		// replaying it
+		pass.recorder.recorded = parser.add
+		parser.pass = &pass
+	}
+
+	return parser, nil
+}
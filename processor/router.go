@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// router indexes parsers by the literal prefix of their patterns, so only the parsers that can match a
+// path are tested. Patterns anchored with ^ and starting with a literal are kept in a prefix trie;
+// every other pattern is always a candidate, filtered by a literal it must contain when there is one.
+// Candidates are returned in the parsers order, preserving first-match-wins semantics
+type router struct {
+	parsers  []parser
+	trie     *trieNode
+	fallback []fallbackRoute
+}
+
+type trieNode struct {
+	children map[byte]*trieNode
+	parsers  []int
+}
+
+type fallbackRoute struct {
+	index    int
+	contains string
+}
+
+func newRouter(parsers []parser) *router {
+	rt := &router{
+		parsers: parsers,
+		trie:    &trieNode{},
+	}
+
+	for i, p := range parsers {
+		prefix, anchored, contains := patternLiterals(p.GetBaseParser().Pattern)
+		if anchored && prefix != "" {
+			rt.trie.insert(prefix, i)
+			continue
+		}
+
+		rt.fallback = append(rt.fallback, fallbackRoute{index: i, contains: contains})
+	}
+
+	return rt
+}
+
+func (n *trieNode) insert(prefix string, index int) {
+	node := n
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*trieNode)
+		}
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			child = &trieNode{}
+			node.children[prefix[i]] = child
+		}
+		node = child
+	}
+	node.parsers = append(node.parsers, index)
+}
+
+// candidates returns, in order, the parsers whose pattern can match the path
+func (rt *router) candidates(path string) []parser {
+	var indexes []int
+
+	node := rt.trie
+	for i := 0; i < len(path) && node != nil; i++ {
+		node = node.children[path[i]]
+		if node != nil {
+			indexes = append(indexes, node.parsers...)
+		}
+	}
+
+	for _, f := range rt.fallback {
+		if f.contains == "" || strings.Contains(path, f.contains) {
+			indexes = append(indexes, f.index)
+		}
+	}
+
+	sort.Ints(indexes)
+
+	out := make([]parser, len(indexes))
+	for i, index := range indexes {
+		out[i] = rt.parsers[index]
+	}
+
+	return out
+}
+
+// patternLiterals returns the literal prefix of a pattern, if it is anchored at the beginning of the text,
+// and a literal that any match must contain
+func patternLiterals(pattern string) (prefix string, anchored bool, contains string) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false, ""
+	}
+	re = re.Simplify()
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+
+	if len(subs) > 0 && subs[0].Op == syntax.OpBeginText {
+		anchored = true
+		subs = subs[1:]
+	}
+
+	for i, sub := range subs {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			continue
+		}
+
+		lit := string(sub.Rune)
+		if contains == "" || len(lit) > len(contains) {
+			contains = lit
+		}
+		if i == 0 && anchored {
+			prefix = lit
+		}
+	}
+
+	return prefix, anchored, contains
+}
@@ -0,0 +1,98 @@
+package processor
+
+import "sync"
+
+// ScenarioStarted is the initial state of every scenario
+const ScenarioStarted = "Started"
+
+// scenarioStore keeps the current state of each scenario
+type scenarioStore struct {
+	mu     sync.Mutex
+	names  map[string]bool
+	states map[string]string
+}
+
+func newScenarioStore() *scenarioStore {
+	return &scenarioStore{
+		names:  make(map[string]bool),
+		states: make(map[string]string),
+	}
+}
+
+func (s *scenarioStore) register(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.names[name] = true
+}
+
+// state returns the current state of a scenario
+func (s *scenarioStore) state(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.states[name]; ok {
+		return state
+	}
+	return ScenarioStarted
+}
+
+// transition moves a scenario to a new state
+func (s *scenarioStore) transition(name string, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.names[name] = true
+	s.states[name] = state
+}
+
+// advance moves a scenario to the next state when it is in the required state, under a single lock.
+// An empty required state matches any state, and an empty next state keeps the current one
+func (s *scenarioStore) advance(name string, required string, next string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.states[name]
+	if !ok {
+		current = ScenarioStarted
+	}
+	if required != "" && current != required {
+		return false
+	}
+
+	if next != "" {
+		s.states[name] = next
+	}
+	return true
+}
+
+// reset moves scenarios back to the initial state. If no names are given, all scenarios are reset
+func (s *scenarioStore) reset(names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(names) == 0 {
+		s.states = make(map[string]string)
+		return
+	}
+
+	for _, name := range names {
+		delete(s.states, name)
+	}
+}
+
+// all returns the current state of every known scenario
+func (s *scenarioStore) all() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]string, len(s.names))
+	for name := range s.names {
+		out[name] = ScenarioStarted
+		if state, ok := s.states[name]; ok {
+			out[name] = state
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,435 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// ScriptHandler is the function a script must define to handle requests: def handle(req, resp)
+const ScriptHandler = "handle"
+
+// ScriptMaxSteps is the number of Starlark steps a script can run when loaded or handling a request,
+// so scripts looping for too long fail instead of blocking the server
+const ScriptMaxSteps = 10000000
+
+// ScriptTimeout is the time a script has to handle a request. It is also cancelled when the client
+// request is
+const ScriptTimeout = 10 * time.Second
+
+// scriptBuiltins are predeclared in every script
+var scriptBuiltins = starlark.StringDict{
+	"json": starlarkstruct.FromStringDict(starlark.String("json"), starlark.StringDict{
+		"encode": starlark.NewBuiltin("json.encode", scriptJSONEncode),
+		"decode": starlark.NewBuiltin("json.decode", scriptJSONDecode),
+	}),
+}
+
+// responseScript runs a Starlark script to build the response. The script is executed once, when
+// loaded, and its frozen handle function is called for every request
+type responseScript struct {
+	baseResponse
+	name    string
+	pattern *regexp.Regexp
+	handle  starlark.Callable
+}
+
+func newResponseScript(base baseResponse, pattern *regexp.Regexp, name string, src string) (*responseScript, error) {
+	thread := newScriptThread(name)
+	globals, err := starlark.ExecFile(thread, name, src, scriptBuiltins)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	globals.Freeze()
+
+	handle, ok := globals[ScriptHandler].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script must define a function %s(req, resp)", ScriptHandler)
+	}
+
+	return &responseScript{
+		baseResponse: base,
+		name:         name,
+		pattern:      pattern,
+		handle:       handle,
+	}, nil
+}
+
+func newScriptThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Info().Str("script", name).Msg(msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(ScriptMaxSteps)
+
+	return thread
+}
+
+// callScript calls a script function, cancelling it on timeout or when the context is done
+func callScript(ctx context.Context, thread *starlark.Thread, fn starlark.Callable, args starlark.Tuple) error {
+	ctx, cancel := context.WithTimeout(ctx, ScriptTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	_, err := starlark.Call(thread, fn, args, nil)
+	return err
+}
+
+// scriptError includes the script backtrace in evaluation errors
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+
+	return err
+}
+
+// WriteResponse writes response for script response type
+func (rs *responseScript) WriteResponse(w http.ResponseWriter, r *http.Request) {
+	data, err := newTemplateData(r, rs.pattern)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Can't read body %v", err), 500)
+		return
+	}
+
+	req, err := newScriptRequest(data)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("error running script: %v", err), 500)
+		return
+	}
+
+	resp := newScriptResponse(rs.Status[r.Method], rs.Headers)
+	thread := newScriptThread(rs.name)
+	if err := callScript(r.Context(), thread, rs.handle, starlark.Tuple{req, resp}); err != nil {
+		errorResponse(w, fmt.Sprintf("error running script: %v", scriptError(err)), 500)
+		return
+	}
+
+	body, err := resp.bodyBytes()
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("error running script: %v", err), 500)
+		return
+	}
+
+	// the status is 0 when the method has no status and the script doesn't set one
+	if !validStatus(int(resp.status)) {
+		errorResponse(w, fmt.Sprintf("error running script: bad status %d, response.status must be set", resp.status), 500)
+		return
+	}
+
+	rs.addMultiValueHeaders(w)
+	for _, item := range resp.headers.Items() {
+		k, _ := starlark.AsString(item[0])
+		v, ok := starlark.AsString(item[1])
+		if !ok {
+			v = item[1].String()
+		}
+		w.Header().Set(k, v)
+	}
+
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(body)
+}
+
+// newScriptRequest exposes the request to scripts as a struct with the same fields of templates
+func newScriptRequest(data templateData) (starlark.Value, error) {
+	params := make([]starlark.Value, len(data.Params))
+	for i, p := range data.Params {
+		params[i] = starlark.String(p)
+	}
+
+	vars := starlark.NewDict(len(data.Vars))
+	for k, v := range data.Vars {
+		_ = vars.SetKey(starlark.String(k), starlark.String(v))
+	}
+
+	jsonBody, err := toStarlark(data.JSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return starlarkstruct.FromStringDict(starlark.String("request"), starlark.StringDict{
+		"method":  starlark.String(data.Method),
+		"path":    starlark.String(data.Path),
+		"params":  starlark.NewList(params),
+		"vars":    vars,
+		"query":   firstValues(data.Query),
+		"headers": firstValues(data.Headers),
+		"cookies": stringDict(data.Cookies),
+		"body":    starlark.String(data.Body),
+		"json":    jsonBody,
+	}), nil
+}
+
+// firstValues converts multi-valued maps (query and headers) to a dict with the first value of each key
+func firstValues(values map[string][]string) *starlark.Dict {
+	d := starlark.NewDict(len(values))
+	for k, v := range values {
+		if len(v) > 0 {
+			_ = d.SetKey(starlark.String(k), starlark.String(v[0]))
+		}
+	}
+
+	return d
+}
+
+func stringDict(m map[string]string) *starlark.Dict {
+	d := starlark.NewDict(len(m))
+	for k, v := range m {
+		_ = d.SetKey(starlark.String(k), starlark.String(v))
+	}
+
+	return d
+}
+
+// scriptResponse is the response builder passed to scripts. Status and body can be set, and headers
+// is a dict. A body that is not a string is encoded as JSON
+type scriptResponse struct {
+	status  int
+	headers *starlark.Dict
+	body    starlark.Value
+}
+
+var (
+	_ starlark.HasAttrs    = (*scriptResponse)(nil)
+	_ starlark.HasSetField = (*scriptResponse)(nil)
+)
+
+func newScriptResponse(status int, headers map[string]string) *scriptResponse {
+	return &scriptResponse{
+		status:  status,
+		headers: stringDict(headers),
+		body:    starlark.String(""),
+	}
+}
+
+func (sr *scriptResponse) String() string        { return fmt.Sprintf("response(status=%d)", sr.status) }
+func (sr *scriptResponse) Type() string          { return "response" }
+func (sr *scriptResponse) Freeze()               {}
+func (sr *scriptResponse) Truth() starlark.Bool  { return starlark.True }
+func (sr *scriptResponse) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: response") }
+
+func (sr *scriptResponse) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "status":
+		return starlark.MakeInt(sr.status), nil
+	case "headers":
+		return sr.headers, nil
+	case "body":
+		return sr.body, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (sr *scriptResponse) AttrNames() []string {
+	return []string{"body", "headers", "status"}
+}
+
+func (sr *scriptResponse) SetField(name string, v starlark.Value) error {
+	switch name {
+	case "status":
+		status, err := starlark.AsInt32(v)
+		if err != nil {
+			return fmt.Errorf("response.status: %w", err)
+		}
+		if !validStatus(int(status)) {
+			return fmt.Errorf("response.status must be from 100 to 999, got %d", status)
+		}
+		sr.status = status
+	case "headers":
+		d, ok := v.(*starlark.Dict)
+		if !ok {
+			return fmt.Errorf("response.headers must be a dict, got %s", v.Type())
+		}
+		sr.headers = d
+	case "body":
+		sr.body = v
+	default:
+		return starlark.NoSuchAttrError(fmt.Sprintf("response has no .%s field", name))
+	}
+
+	return nil
+}
+
+func (sr *scriptResponse) bodyBytes() ([]byte, error) {
+	if body, ok := sr.body.(starlark.String); ok {
+		return []byte(body), nil
+	}
+
+	v, err := fromStarlark(sr.body)
+	if err != nil {
+		return nil, fmt.Errorf("response.body: %w", err)
+	}
+
+	if !sr.hasHeader("content-type") {
+		_ = sr.headers.SetKey(starlark.String("content-type"), starlark.String("application/json"))
+	}
+
+	return json.Marshal(v)
+}
+
+func (sr *scriptResponse) hasHeader(name string) bool {
+	for _, k := range sr.headers.Keys() {
+		if s, ok := starlark.AsString(k); ok && http.CanonicalHeaderKey(s) == http.CanonicalHeaderKey(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func scriptJSONEncode(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+
+	out, err := fromStarlark(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	return starlark.String(encoded), nil
+}
+
+func scriptJSONDecode(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	return toStarlark(v)
+}
+
+// toStarlark converts a value decoded from JSON to a Starlark value
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for i, e := range v {
+			var err error
+			if elems[i], err = toStarlark(e); err != nil {
+				return nil, err
+			}
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		d := starlark.NewDict(len(v))
+		for _, k := range keys {
+			e, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			_ = d.SetKey(starlark.String(k), e)
+		}
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %T", v)
+	}
+}
+
+// fromStarlark converts a Starlark value to a value that can be encoded as JSON
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return strconv.ParseFloat(v.String(), 64)
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Indexable:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			var err error
+			if out[i], err = fromStarlark(v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case *starlark.Dict:
+		out := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			e, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			out[k] = e
+		}
+		return out, nil
+	case *starlarkstruct.Struct:
+		d := starlark.StringDict{}
+		v.ToStringDict(d)
+		out := make(map[string]interface{}, len(d))
+		for k, e := range d {
+			var err error
+			if out[k], err = fromStarlark(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("can't encode %s as JSON", v.Type())
+	}
+}
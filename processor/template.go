@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateData is the data exposed to response templates
+type templateData struct {
+	Method  string
+	Path    string
+	Params  []string
+	Vars    map[string]string
+	Query   url.Values
+	Headers http.Header
+	Cookies map[string]string
+	Body    string
+	JSON    interface{}
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func newTemplateData(r *http.Request, pattern *regexp.Regexp) (templateData, error) {
+	data := templateData{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Vars:    make(map[string]string),
+		Query:   r.URL.Query(),
+		Headers: r.Header,
+		Cookies: make(map[string]string),
+		JSON:    map[string]interface{}{},
+	}
+
+	if pattern != nil {
+		data.Params = pattern.FindStringSubmatch(r.URL.Path)
+		for i, name := range pattern.SubexpNames() {
+			if name != "" && i < len(data.Params) {
+				data.Vars[name] = data.Params[i]
+			}
+		}
+	}
+
+	for _, c := range r.Cookies() {
+		data.Cookies[c.Name] = c.Value
+	}
+
+	body, err := bufferBody(r)
+	if err != nil {
+		return templateData{}, err
+	}
+	data.Body = string(body)
+
+	if len(body) > 0 {
+		// a body that is not valid JSON is still available as .Body
+		var v interface{}
+		if json.Unmarshal(body, &v) == nil {
+			data.JSON = v
+		}
+	}
+
+	return data, nil
+}
+
+func executeTemplate(t *template.Template, data templateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+type responseTemplate struct {
+	baseResponse
+	pattern        *regexp.Regexp
+	body           *template.Template
+	headers        map[string]*template.Template
+	statusTemplate *template.Template
+}
+
+func newResponseTemplate(base baseResponse, pattern *regexp.Regexp, body string, status string) (*responseTemplate, error) {
+	rt := &responseTemplate{
+		baseResponse: base,
+		pattern:      pattern,
+		headers:      make(map[string]*template.Template),
+	}
+
+	var err error
+	rt.body, err = parseTemplate("body", body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing body template: %w", err)
+	}
+
+	for k, v := range base.Headers {
+		rt.headers[k], err = parseTemplate(k, v)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing header %s template: %w", k, err)
+		}
+	}
+
+	if status != "" {
+		rt.statusTemplate, err = parseTemplate("status", status)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing status template: %w", err)
+		}
+	}
+
+	return rt, nil
+}
+
+// WriteResponse writes response for template response type
+func (rt *responseTemplate) WriteResponse(w http.ResponseWriter, r *http.Request) {
+	data, err := newTemplateData(r, rt.pattern)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("Can't read body %v", err), 500)
+		return
+	}
+
+	body, err := executeTemplate(rt.body, data)
+	if err != nil {
+		errorResponse(w, fmt.Sprintf("error rendering body template: %v", err), 500)
+		return
+	}
+
+	status := rt.Status[r.Method]
+	if rt.statusTemplate != nil {
+		out, err := executeTemplate(rt.statusTemplate, data)
+		if err != nil {
+			errorResponse(w, fmt.Sprintf("error rendering status template: %v", err), 500)
+			return
+		}
+
+		// an empty result falls back to the configured status for the method
+		if out = strings.TrimSpace(out); out != "" {
+			status, err = strconv.Atoi(out)
+			if err != nil {
+				errorResponse(w, fmt.Sprintf("bad status rendered by template: %s", out), 500)
+				return
+			}
+		}
+	}
+	if !validStatus(status) {
+		errorResponse(w, fmt.Sprintf("bad status %d for %s: the template must render a status from 100 to 999 or the status for the method must be set", status, r.Method), 500)
+		return
+	}
+
+	headers := make(map[string]string, len(rt.headers))
+	for k, t := range rt.headers {
+		v, err := executeTemplate(t, data)
+		if err != nil {
+			errorResponse(w, fmt.Sprintf("error rendering header %s template: %v", k, err), 500)
+			return
+		}
+		headers[k] = v
+	}
+
+	for k, v := range headers {
+		w.Header().Add(k, v)
+	}
+	rt.addMultiValueHeaders(w)
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+}
@@ -0,0 +1,4 @@
+#!/bin/sh
+# Moves the request to /transformed, keeping its body
+body=$(sed -n 's/.*"body":"\([^"]*\)".*/\1/p')
+echo "{\"path\": \"/transformed\", \"headers\": {\"X-Plugin\": [\"transform\"]}, \"body\": \"$body!\"}"
@@ -0,0 +1,14 @@
+#!/bin/sh
+# Answers exec requests with the id of the user, the call count and the process id.
+# The user "crash" exits, the user "slow" takes too long to answer and the user "bad" answers an invalid status
+n=0
+while read -r line; do
+  n=$((n+1))
+  id=$(echo "$line" | sed -n 's/.*"vars":{"id":"\([^"]*\)"}.*/\1/p')
+  case "$id" in
+    crash) exit 1 ;;
+    slow) sleep 2 ;;
+    bad) echo '{"status": 1000, "body": "bad"}'; continue ;;
+  esac
+  echo "{\"status\": 0, \"headers\": {\"x-plugin\": \"user\"}, \"body\": \"user $id call $n pid $$\"}"
+done
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 )
 
 // AddHeader adds an header to request
@@ -15,3 +19,19 @@ func AddHeader(r *http.Request) error {
 
 	return nil
 }
+
+// WrapResponse wraps the response body in a data field
+func WrapResponse(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	wrapped := fmt.Sprintf(`{"data": %s}`, body)
+	resp.Body = ioutil.NopCloser(strings.NewReader(wrapped))
+	resp.ContentLength = int64(len(wrapped))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(wrapped)))
+
+	return nil
+}
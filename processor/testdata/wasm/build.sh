@@ -0,0 +1,3 @@
+#!/bin/bash
+
+GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o plugin.wasm plugin.go
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+// request and response are the JSON messages of WASM plugins
+type request struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Query   map[string][]string `json:"query"`
+	Headers map[string][]string `json:"headers"`
+	Vars    map[string]string   `json:"vars"`
+	Body    string              `json:"body"`
+	Status  int                 `json:"status"`
+}
+
+type response struct {
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+// buffers keeps the memory given to the host alive until the next call
+var buffers [][]byte
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	buf := make([]byte, size)
+	buffers = append(buffers, buf)
+	return pointer(buf)
+}
+
+// Greet answers with a greeting to the name in the path
+//
+//go:wasmexport Greet
+func Greet(ptr uint32, size uint32) uint64 {
+	var req request
+	if err := json.Unmarshal(input(ptr, size), &req); err != nil {
+		return output(response{Status: 500, Body: err.Error()})
+	}
+
+	return output(response{
+		Status:  req.Status,
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    "hello " + req.Vars["name"],
+	})
+}
+
+// AddHeader adds the X-Wasm header to the request
+//
+//go:wasmexport AddHeader
+func AddHeader(ptr uint32, size uint32) uint64 {
+	var req request
+	if err := json.Unmarshal(input(ptr, size), &req); err != nil {
+		return output(req)
+	}
+
+	if req.Headers == nil {
+		req.Headers = make(map[string][]string)
+	}
+	req.Headers["X-Wasm"] = []string{req.Method + " " + req.Path}
+
+	return output(req)
+}
+
+func input(ptr uint32, size uint32) []byte {
+	b := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(ptr))), size)
+	buffers = nil
+	return b
+}
+
+// output returns the pointer to the JSON message in the high 32 bits and its size in the low ones
+func output(v interface{}) uint64 {
+	b, _ := json.Marshal(v)
+	buffers = append(buffers, b)
+	return uint64(pointer(b))<<32 | uint64(len(b))
+}
+
+func pointer(b []byte) uint32 {
+	if len(b) == 0 {
+		return 0
+	}
+	return uint32(uintptr(unsafe.Pointer(&b[0])))
+}
+
+func main() {}
@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// throttle limits the speed of the responses of a parser
+type throttle struct {
+	bytesPerSecond  int
+	chunkSize       int
+	timeToFirstByte time.Duration
+}
+
+// newThrottle returns nil when no throttling is configured
+func newThrottle(conf config.Throttle) (*throttle, error) {
+	if conf.BytesPerSecond == 0 && conf.TimeToFirstByte == "" {
+		return nil, nil
+	}
+
+	t := &throttle{bytesPerSecond: conf.BytesPerSecond, chunkSize: conf.ChunkSize}
+	if t.chunkSize == 0 {
+		// by default, the body is sent 10 times per second
+		t.chunkSize = t.bytesPerSecond / 10
+	}
+	if t.chunkSize == 0 {
+		t.chunkSize = 1
+	}
+
+	if err := parseDuration("time-to-first-byte", conf.TimeToFirstByte, &t.timeToFirstByte); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// throttleWriter is a http.ResponseWriter waiting the time to first byte before the response starts,
+// then sending the body in chunks, flushed and paced to the configured rate. Flush and Hijack are the
+// ones of captureWriter
+type throttleWriter struct {
+	*captureWriter
+	throttle *throttle
+	started  bool
+	start    time.Time
+	sent     int64
+}
+
+func (t *throttle) wrap(w http.ResponseWriter) *throttleWriter {
+	return &throttleWriter{captureWriter: newCaptureWriter(w, false), throttle: t}
+}
+
+// firstByte waits the time to first byte, once
+func (tw *throttleWriter) firstByte() {
+	if tw.started {
+		return
+	}
+	tw.started = true
+
+	time.Sleep(tw.throttle.timeToFirstByte)
+	tw.start = time.Now()
+}
+
+func (tw *throttleWriter) WriteHeader(status int) {
+	tw.firstByte()
+	tw.captureWriter.WriteHeader(status)
+}
+
+func (tw *throttleWriter) Write(b []byte) (int, error) {
+	tw.firstByte()
+
+	if tw.throttle.bytesPerSecond == 0 {
+		return tw.captureWriter.Write(b)
+	}
+
+	written := 0
+	for written < len(b) {
+		end := written + tw.throttle.chunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+
+		n, err := tw.captureWriter.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		tw.Flush()
+
+		// waits until the time the bytes sent so far take at the configured rate
+		tw.sent += int64(n)
+		due := tw.start.Add(time.Duration(tw.sent * int64(time.Second) / int64(tw.throttle.bytesPerSecond)))
+		time.Sleep(time.Until(due))
+	}
+
+	return written, nil
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+)
+
+// upstreamTransport is the transport of a pass parser with transport options, owning its connections
+type upstreamTransport struct {
+	*http.Transport
+}
+
+// stop closes the idle connections, so they don't outlive the parser
+func (t *upstreamTransport) stop() {
+	t.CloseIdleConnections()
+}
+
+// createTransport creates the transport of a pass parser to its upstreams. It returns nil for the
+// parsers without transport options, which share the default transport (and its connections)
+func createTransport(cr config.Parser) (*upstreamTransport, error) {
+	tc := cr.Transport
+	if tc == (config.Transport{}) && cr.Fallback.Timeout == "" {
+		return nil, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if err := parseDuration("tls handshake timeout", tc.TLSHandshakeTimeout, &t.TLSHandshakeTimeout); err != nil {
+		return nil, err
+	}
+	if err := parseDuration("response header timeout", tc.ResponseHeaderTimeout, &t.ResponseHeaderTimeout); err != nil {
+		return nil, err
+	}
+	// the fallback timeout overrides the response header timeout, since it triggers the fallback
+	if err := parseDuration("fallback timeout", cr.Fallback.Timeout, &t.ResponseHeaderTimeout); err != nil {
+		return nil, err
+	}
+
+	var dialTimeout time.Duration
+	if err := parseDuration("dial timeout", tc.DialTimeout, &dialTimeout); err != nil {
+		return nil, err
+	}
+	if dialTimeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	if tc.Proxy != "" {
+		proxy, err := url.Parse(tc.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing proxy url %s: %w", tc.Proxy, err)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+
+	if tc.HTTP2 != nil && !*tc.HTTP2 {
+		// a non nil empty map disables HTTP/2
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if tc.MaxIdleConns > 0 {
+		t.MaxIdleConns = tc.MaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	}
+
+	tlsConfig, err := createUpstreamTLSConfig(tc)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+
+	return &upstreamTransport{Transport: t}, nil
+}
+
+// createUpstreamTLSConfig returns nil when no TLS option is set
+func createUpstreamTLSConfig(tc config.Transport) (*tls.Config, error) {
+	if tc.CA == "" && tc.Cert == "" && !tc.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	conf := &tls.Config{InsecureSkipVerify: tc.InsecureSkipVerify}
+
+	if tc.CA != "" {
+		b, err := ioutil.ReadFile(tc.CA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading upstream CA: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in upstream CA %s", tc.CA)
+		}
+		conf.RootCAs = pool
+	}
+
+	if tc.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(tc.Cert, tc.Key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading upstream client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
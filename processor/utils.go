@@ -1,11 +1,14 @@
 package processor
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"plugin"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -48,6 +51,30 @@ func loadTransformFunc(lib string, symbol string) (transform, error) {
 	return transform{tranformFunc: f}, nil
 }
 
+func loadResponseTransformFunc(lib string, symbol string) (responseTransform, error) {
+	p, err := plugin.Open(lib)
+	if err != nil {
+		return responseTransform{}, err
+	}
+
+	s, err := p.Lookup(symbol)
+	if err != nil {
+		return responseTransform{}, err
+	}
+
+	f, ok := s.(func(resp *http.Response) error)
+	if !ok {
+		return responseTransform{}, errors.New("transform response symbol must have this signature: func(resp *http.Response) error")
+	}
+
+	return responseTransform{transformFunc: f}, nil
+}
+
+// validStatus tells if a status can be written, since net/http panics on codes outside 100-999
+func validStatus(status int) bool {
+	return status >= 100 && status <= 999
+}
+
 func errorResponse(w http.ResponseWriter, message string, status int) {
 	w.Header().Add("content-type", "text/plain")
 	w.WriteHeader(status)
@@ -75,3 +102,35 @@ func logResponse(resp *http.Response) {
 
 	log.Info().Msgf("Response: %s", respBody)
 }
+
+// bufferBody reads the whole request body and restores it, so it can be read again
+func bufferBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// parseDuration parses an optional duration into dest, which is left unchanged when the value is empty
+func parseDuration(name string, value string, dest *time.Duration) error {
+	if value == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", name, err)
+	}
+	*dest = d
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WasmAllocSymbol is the function a WASM plugin exports to allocate the memory of the messages sent to it:
+// alloc(size u32) u32
+const WasmAllocSymbol = "alloc"
+
+// DefaultWasmTimeout is the time a WASM plugin has to answer a message
+const DefaultWasmTimeout = 10 * time.Second
+
+// wasmCache keeps the compiled modules, so reloading a configuration doesn't compile them again
+var wasmCache = wazero.NewCompilationCache()
+
+// isWasm tells if a plugin lib is a WASM module instead of a Go plugin
+func isWasm(lib string) bool {
+	return strings.HasSuffix(lib, ".wasm")
+}
+
+// wasmPlugin runs a function of a WebAssembly module with the messages of exec plugins. The message is
+// written to memory given by alloc, and the function, called with its pointer and size, returns the
+// pointer (high 32 bits) and size (low 32 bits) of the answer. Like exec workers, a single instance
+// answers one message at a time, and it is instantiated again after failing or timing out
+type wasmPlugin struct {
+	lib      string
+	symbol   string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	mu     sync.Mutex
+	module api.Module
+}
+
+func newWasmPlugin(lib string, symbol string) (*wasmPlugin, error) {
+	b, err := ioutil.ReadFile(lib)
+	if err != nil {
+		return nil, fmt.Errorf("error reading wasm module: %w", err)
+	}
+
+	ctx := context.Background()
+	conf := wazero.NewRuntimeConfig().WithCompilationCache(wasmCache).WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(ctx, conf)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	compiled, err := runtime.CompileModule(ctx, b)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("error compiling wasm module %s: %w", lib, err)
+	}
+
+	exports := compiled.ExportedFunctions()
+	for _, name := range []string{WasmAllocSymbol, symbol} {
+		if _, ok := exports[name]; !ok {
+			_ = runtime.Close(ctx)
+			return nil, fmt.Errorf("wasm module %s must export the function %s", lib, name)
+		}
+	}
+
+	return &wasmPlugin{lib: lib, symbol: symbol, runtime: runtime, compiled: compiled}, nil
+}
+
+// call sends in to the plugin and decodes its answer to out
+func (wp *wasmPlugin) call(in interface{}, out interface{}) error {
+	msg, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultWasmTimeout)
+	defer cancel()
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.module == nil {
+		// reactor modules (Go, TinyGo and Rust libraries) are initialized by _initialize
+		conf := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize").
+			WithStdout(os.Stderr).WithStderr(os.Stderr)
+		wp.module, err = wp.runtime.InstantiateModule(ctx, wp.compiled, conf)
+		if err != nil {
+			wp.module = nil
+			return fmt.Errorf("error instantiating wasm module %s: %w", wp.lib, err)
+		}
+	}
+
+	answer, err := wp.exchange(ctx, msg)
+	if err != nil {
+		// the instance may be broken, a new one is created for the next message
+		_ = wp.module.Close(ctx)
+		wp.module = nil
+		return fmt.Errorf("error calling wasm %s: %w", wp.symbol, err)
+	}
+
+	if err := json.Unmarshal(answer, out); err != nil {
+		return fmt.Errorf("error parsing wasm %s answer: %w", wp.symbol, err)
+	}
+
+	return nil
+}
+
+func (wp *wasmPlugin) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	res, err := wp.module.ExportedFunction(WasmAllocSymbol).Call(ctx, uint64(len(msg)))
+	if err != nil {
+		return nil, err
+	}
+
+	ptr := uint32(res[0])
+	if !wp.module.Memory().Write(ptr, msg) {
+		return nil, errors.New("message out of the module memory")
+	}
+
+	res, err = wp.module.ExportedFunction(wp.symbol).Call(ctx, uint64(ptr), uint64(len(msg)))
+	if err != nil {
+		return nil, err
+	}
+
+	answer, ok := wp.module.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, errors.New("answer out of the module memory")
+	}
+
+	// the memory is reused by the next calls
+	return append([]byte(nil), answer...), nil
+}
+
+func (wp *wasmPlugin) stop() {
+	if wp != nil {
+		_ = wp.runtime.Close(context.Background())
+	}
+}
+
+// wasmTransform transforms pass requests with a WASM plugin, like transform exec plugins
+func wasmTransform(wp *wasmPlugin) transform {
+	return transform{tranformFunc: func(r *http.Request) error {
+		return transformRequest(wp, r)
+	}}
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// captureWriter is a http.ResponseWriter that keeps a copy of the status and body written
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func newCaptureWriter(w http.ResponseWriter, keepBody bool) *captureWriter {
+	cw := &captureWriter{ResponseWriter: w}
+	if keepBody {
+		cw.body = &bytes.Buffer{}
+	}
+
+	return cw
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.body != nil {
+		cw.body.Write(b)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, used by the reverse proxy to stream responses
+func (cw *captureWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker when the wrapped writer supports it
+func (cw *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return h.Hijack()
+}
+
+// Status returns the status written, or 200 if nothing was written explicitly
+func (cw *captureWriter) Status() int {
+	if cw.status == 0 {
+		return http.StatusOK
+	}
+	return cw.status
+}
+
+// newBufferWriter returns a captureWriter keeping the response instead of sending it
+func newBufferWriter() *captureWriter {
+	return newCaptureWriter(discardWriter{header: make(http.Header)}, true)
+}
+
+// discardWriter is a http.ResponseWriter that only keeps the headers
+type discardWriter struct {
+	header http.Header
+}
+
+func (dw discardWriter) Header() http.Header {
+	return dw.header
+}
+
+func (dw discardWriter) WriteHeader(int) {}
+
+func (dw discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/rodrigo-kayala/mirage-mocker/config"
+	"github.com/rodrigo-kayala/mirage-mocker/processor"
+)
+
+// watchedPaths returns the config file and every file or folder referenced by it
+func watchedPaths(configFile string, c config.Config) []string {
+	paths := []string{configFile}
+	for _, service := range c.Services {
+		for _, resp := range []config.Response{service.Parser.Response, service.Parser.Fallback.Response} {
+			if resp.BodyFile != "" {
+				paths = append(paths, resp.BodyFile)
+			}
+			if resp.MagicHeaderFolder != "" {
+				paths = append(paths, resp.MagicHeaderFolder)
+			}
+		}
+		if service.Parser.OpenAPI.Spec != "" {
+			paths = append(paths, service.Parser.OpenAPI.Spec)
+		}
+		if service.Parser.Validation.Spec != "" {
+			paths = append(paths, service.Parser.Validation.Spec)
+		}
+		for _, path := range []string{service.Parser.Transport.CA, service.Parser.Transport.Cert, service.Parser.Transport.Key} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	return paths
+}
+
+// snapshot describes the current state of the watched paths. Folders are described by their files
+func snapshot(paths []string) string {
+	var b strings.Builder
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: %v\n", p, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %d %d\n", p, info.Size(), info.ModTime().UnixNano())
+
+		if !info.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: %v\n", p, err)
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+		for _, f := range files {
+			fmt.Fprintf(&b, "%s/%s: %d %d\n", p, f.Name(), f.Size(), f.ModTime().UnixNano())
+		}
+	}
+
+	return b.String()
+}
+
+// watchConfig polls the config file and the files it references, reloading the processor on changes.
+// A config that fails to load is logged and the current one is kept
+func watchConfig(configFile string, rp processor.Processor, interval time.Duration) {
+	c, err := config.Load(configFile)
+	if err != nil {
+		log.Error().Err(err).Msg("error loading config to watch")
+	}
+	paths := watchedPaths(configFile, c)
+	last := snapshot(paths)
+
+	for range time.Tick(interval) {
+		current := snapshot(paths)
+		if current == last {
+			continue
+		}
+		last = current
+
+		c, err := config.Load(configFile)
+		if err != nil {
+			log.Error().Err(err).Msg("error reloading config, keeping the current one")
+			continue
+		}
+
+		if err := rp.Reload(c); err != nil {
+			log.Error().Err(err).Msg("error reloading config, keeping the current one")
+			continue
+		}
+
+		// the new config may reference other files
+		paths = watchedPaths(configFile, c)
+		last = snapshot(paths)
+		log.Info().Msgf("config reloaded: %s", configFile)
+	}
+}